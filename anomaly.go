@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// names of the anomaly checks, stored in the `check_name` column
+const (
+	checkBounds       = "bounds"
+	checkJump         = "jump"
+	checkBuildingZero = "building_zero"
+	checkZScore       = "zscore"
+)
+
+// anomaly is a suspicious client count found while ingesting a dump.
+// Expected is the value the check compared against, if it had one.
+type anomaly struct {
+	DumpTime    time.Time
+	GroupID     int
+	Check       string
+	ClientCount int
+	Expected    sql.NullFloat64
+	Detail      string
+}
+
+// anomalyConfig holds the thresholds for each of the anomaly checks.
+type anomalyConfig struct {
+	MinCount int     // lowest acceptable count
+	MaxCount int     // highest acceptable count
+	MaxJump  int     // largest change from the previous dump
+	ZScore   float64 // largest deviation from the same slot in previous weeks
+	Weeks    int     // number of previous weeks to compare against
+	Exclude  bool    // whether anomalous counts are excluded from the rollups
+}
+
+// anomalyChecks is configured by the command line flags.
+var anomalyChecks = anomalyConfig{
+	MinCount: 0,
+	MaxCount: 2000,
+	MaxJump:  250,
+	ZScore:   4,
+	Weeks:    4,
+}
+
+// minZScoreSamples is the fewest previous weeks needed to compute a z-score.
+const minZScoreSamples = 3
+
+// detectAnomalies runs every check against the dataset.
+//
+// `previous` holds the counts from the previous dump and `history` the counts for
+// the same time slot in previous weeks, both keyed by group ID.
+func (data dataset) detectAnomalies(cfg anomalyConfig, previous map[int]int, history map[int][]int) []anomaly {
+	buildings := newBuildingCounts()
+	for _, d := range data {
+		buildings.add(d, previous)
+	}
+
+	var found []anomaly
+	for _, d := range data {
+		found = append(found, d.detectAnomalies(cfg, previous, history, buildings.zero(d.ParentID))...)
+	}
+	return found
+}
+
+// buildingCounts tracks, by parent ID, whether every group is zero now and whether
+// any group had clients in the previous dump, so a building dropping to zero can be
+// found a record at a time.
+type buildingCounts struct {
+	allZero    map[int]bool
+	hadClients map[int]bool
+}
+
+// newBuildingCounts starts counting the groups of a dump.
+func newBuildingCounts() buildingCounts {
+	return buildingCounts{allZero: make(map[int]bool), hadClients: make(map[int]bool)}
+}
+
+// add a group, with the counts from the previous dump by group ID.
+func (b buildingCounts) add(d dumpFormat, previous map[int]int) {
+	if d.NullCount {
+		return
+	}
+	if _, exists := b.allZero[d.ParentID]; !exists {
+		b.allZero[d.ParentID] = true
+	}
+	if d.ClientCount != 0 {
+		b.allZero[d.ParentID] = false
+	}
+	if prev, exists := previous[d.GroupID]; exists && prev > 0 {
+		b.hadClients[d.ParentID] = true
+	}
+}
+
+// zero is whether every group of the parent dropped to zero.
+func (b buildingCounts) zero(parentID int) bool {
+	return b.allZero[parentID] && b.hadClients[parentID]
+}
+
+// detectAnomalies runs the checks of a single group.
+//
+// buildingZero is whether every group in the group's parent dropped to zero, which
+// can only be known with the whole dataset.
+func (df dumpFormat) detectAnomalies(cfg anomalyConfig, previous map[int]int, history map[int][]int, buildingZero bool) []anomaly {
+	// there's nothing to check without a count
+	if df.NullCount {
+		return nil
+	}
+
+	var found []anomaly
+	flag := func(check string, expected sql.NullFloat64, detail string, args ...interface{}) {
+		found = append(found, anomaly{
+			DumpTime:    df.DumpTime,
+			GroupID:     df.GroupID,
+			Check:       check,
+			ClientCount: df.ClientCount,
+			Expected:    expected,
+			Detail:      fmt.Sprintf(detail, args...),
+		})
+	}
+
+	if df.ClientCount < cfg.MinCount || df.ClientCount > cfg.MaxCount {
+		flag(checkBounds, sql.NullFloat64{},
+			"count outside of [%d, %d]", cfg.MinCount, cfg.MaxCount)
+	}
+
+	if prev, exists := previous[df.GroupID]; exists {
+		expected := sql.NullFloat64{Float64: float64(prev), Valid: true}
+		if buildingZero {
+			flag(checkBuildingZero, expected,
+				"every group in parent %d dropped to zero", df.ParentID)
+		} else if jump := df.ClientCount - prev; jump > cfg.MaxJump || -jump > cfg.MaxJump {
+			flag(checkJump, expected,
+				"changed by %d since the previous dump", jump)
+		}
+	}
+
+	if counts := history[df.GroupID]; len(counts) >= minZScoreSamples {
+		mean, stddev := meanStddev(counts)
+		if stddev > 0 {
+			z := (float64(df.ClientCount) - mean) / stddev
+			if math.Abs(z) > cfg.ZScore {
+				flag(checkZScore, sql.NullFloat64{Float64: mean, Valid: true},
+					"z-score of %.2f against %d previous weeks", z, len(counts))
+			}
+		}
+	}
+
+	return found
+}
+
+// meanStddev calculates the mean and population standard deviation of the counts.
+func meanStddev(counts []int) (float64, float64) {
+	var sum float64
+	for _, c := range counts {
+		sum += float64(c)
+	}
+	mean := sum / float64(len(counts))
+
+	var variance float64
+	for _, c := range counts {
+		variance += math.Pow(float64(c)-mean, 2)
+	}
+	return mean, math.Sqrt(variance / float64(len(counts)))
+}
+
+// checkAnomalies loads the counts needed to check the dataset from the database,
+// then records every anomaly found.
+//
+// Groups with anomalies are marked as excluded if configured to.
+func (data dataset) checkAnomalies(db *sql.DB, dumpTime time.Time) []anomaly {
+	previous, history := loadAnomalyHistory(db, dumpTime)
+
+	found := data.detectAnomalies(anomalyChecks, previous, history)
+	if len(found) == 0 {
+		return found
+	}
+	log.Printf("WARNING: %d anomalies found in dump at %s", len(found), dumpTime)
+
+	if anomalyChecks.Exclude {
+		anomalous := make(map[int]bool)
+		for _, a := range found {
+			anomalous[a.GroupID] = true
+		}
+		for i, d := range data {
+			data[i].Excluded = anomalous[d.GroupID]
+		}
+	}
+	return found
+}
+
+// loadAnomalyHistory loads the previous counts and the counts from the same slot
+// in previous weeks that the checks compare against, logging any failures.
+func loadAnomalyHistory(db *sql.DB, dumpTime time.Time) (map[int]int, map[int][]int) {
+	previous, err := loadPreviousCounts(db, dumpTime)
+	if err != nil {
+		log.Printf("ERROR: Failed to load previous counts => %s", err.Error())
+	}
+
+	history, err := loadSameSlotCounts(db, dumpTime, anomalyChecks.Weeks)
+	if err != nil {
+		log.Printf("ERROR: Failed to load counts from previous weeks => %s", err.Error())
+	}
+	return previous, history
+}
+
+// loadPreviousCounts gets the counts from the last dump before the given time.
+func loadPreviousCounts(db *sql.DB, dumpTime time.Time) (map[int]int, error) {
+	rows, err := db.Query(`SELECT group_id, client_count
+		FROM density_data
+		WHERE dump_time = (
+			SELECT MAX(dump_time) FROM density_data WHERE dump_time < $1
+		) AND client_count IS NOT NULL`, dumpTime)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query previous counts => {%s}", err)
+	}
+	return scanCounts(rows)
+}
+
+// loadSameSlotCounts gets the counts at the same time of the week for the given
+// number of previous weeks.
+func loadSameSlotCounts(db *sql.DB, dumpTime time.Time, weeks int) (map[int][]int, error) {
+	if weeks <= 0 {
+		return nil, nil
+	}
+
+	var (
+		placeholders = make([]string, weeks)
+		args         = make([]interface{}, weeks)
+	)
+	for i := range placeholders {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = dumpTime.AddDate(0, 0, -7*(i+1))
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT group_id, client_count
+		FROM density_data
+		WHERE dump_time IN (%s) AND client_count IS NOT NULL`, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query counts from previous weeks => {%s}", err)
+	}
+	defer rows.Close()
+
+	history := make(map[int][]int)
+	for rows.Next() {
+		var id, count int
+		if err = rows.Scan(&id, &count); err != nil {
+			return nil, fmt.Errorf("Failed to scan count => {%s}", err)
+		}
+		history[id] = append(history[id], count)
+	}
+	return history, rows.Err()
+}
+
+// scanCounts reads group ID and client count rows into a map.
+func scanCounts(rows *sql.Rows) (map[int]int, error) {
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, fmt.Errorf("Failed to scan count => {%s}", err)
+		}
+		counts[id] = count
+	}
+	return counts, rows.Err()
+}
+
+// insertAnomalies records the anomalies in the `anomalies` table.
+func insertAnomalies(db *sql.DB, anomalies []anomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	stmt, err := txn.Prepare(`INSERT INTO anomalies
+		(dump_time, group_id, check_name, client_count, expected, detail)
+		VALUES ($1, $2, $3, $4, $5, $6)`)
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Error prepping PG txn => %s", err.Error())
+	}
+	defer stmt.Close()
+
+	for _, a := range anomalies {
+		if _, err = stmt.Exec(a.DumpTime, a.GroupID, a.Check, a.ClientCount, a.Expected, a.Detail); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to insert anomaly => %s", err.Error())
+		}
+	}
+
+	return txn.Commit()
+}
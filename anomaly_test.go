@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+// countAnomalies tallies the anomalies found per group and check.
+func countAnomalies(found []anomaly) map[int]map[string]bool {
+	checks := make(map[int]map[string]bool)
+	for _, a := range found {
+		if checks[a.GroupID] == nil {
+			checks[a.GroupID] = make(map[string]bool)
+		}
+		checks[a.GroupID][a.Check] = true
+	}
+	return checks
+}
+
+// TestDetectAnomalies runs each check against a small dataset.
+func TestDetectAnomalies(t *testing.T) {
+	data := dataset{
+		{GroupID: 1, ParentID: 10, ClientCount: -5},
+		{GroupID: 2, ParentID: 10, ClientCount: 500},
+		{GroupID: 3, ParentID: 10, ClientCount: 51},
+		{GroupID: 4, ParentID: 20, ClientCount: 0},
+		{GroupID: 5, ParentID: 20, ClientCount: 0},
+		{GroupID: 6, ParentID: 30, ClientCount: 90},
+	}
+	previous := map[int]int{1: 0, 2: 100, 3: 55, 4: 120, 5: 80, 6: 40}
+	history := map[int][]int{
+		3: {50, 52, 48, 50},
+		6: {40, 42, 38, 40},
+	}
+
+	checks := countAnomalies(data.detectAnomalies(anomalyChecks, previous, history))
+	expected := map[int][]string{
+		1: {checkBounds},
+		2: {checkJump},
+		4: {checkBuildingZero},
+		5: {checkBuildingZero},
+		6: {checkZScore},
+	}
+
+	for id, names := range expected {
+		for _, name := range names {
+			if !checks[id][name] {
+				t.Errorf("Expected group %d to fail the %s check", id, name)
+			}
+		}
+		if len(checks[id]) != len(names) {
+			t.Errorf("Expected group %d to fail %v, found %v", id, names, checks[id])
+		}
+	}
+	if len(checks[3]) != 0 {
+		t.Errorf("Expected group 3 to pass, found %v", checks[3])
+	}
+}
+
+// TestZScoreNeedsHistory checks that no z-score is computed with too few weeks.
+func TestZScoreNeedsHistory(t *testing.T) {
+	data := dataset{{GroupID: 1, ClientCount: 500}}
+	history := map[int][]int{1: {10, 12}}
+
+	if found := data.detectAnomalies(anomalyChecks, nil, history); len(found) != 0 {
+		t.Errorf("Expected no anomalies, found %#v", found)
+	}
+}
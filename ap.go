@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// apCount is the number of clients on a single access point. An export's count
+// that can't be parsed is kept as invalid, making its group's count invalid.
+type apCount struct {
+	Name    string
+	Clients int
+	invalid error
+}
+
+// accessPointsField pulls the optional 'access_points' out of a group's generic map.
+// Each access point is an object with a 'name' and a 'client_count', which may be
+// a number or a string-encoded number.
+func accessPointsField(raw map[string]interface{}) ([]apCount, error) {
+	value, exists := raw["access_points"]
+	if !exists || value == nil {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Value in 'access_points' should be an array")
+	}
+
+	counts := make([]apCount, len(list))
+	for i, item := range list {
+		ap, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Access point %d should be an object", i)
+		}
+
+		name, ok := ap["name"].(string)
+		if !ok {
+			return nil, fmt.Errorf("Value in 'name' of access point %d should be string", i)
+		}
+		clients, err := intField(ap, "client_count")
+		if err != nil {
+			return nil, fmt.Errorf("Access point %s => %s", name, err.Error())
+		}
+		counts[i] = apCount{Name: name, Clients: clients}
+	}
+	return counts, nil
+}
+
+// validateAccessPoints drops the access points of any group whose counts don't
+// add up to the group's total, the total is kept either way.
+func (data dataset) validateAccessPoints() {
+	for i := range data {
+		data[i].validateAccessPoints()
+	}
+}
+
+// validateAccessPoints drops the group's access points if their counts don't add
+// up to its total.
+func (df *dumpFormat) validateAccessPoints() {
+	if len(df.AccessPoints) == 0 {
+		return
+	}
+
+	var sum int
+	for _, ap := range df.AccessPoints {
+		sum += ap.Clients
+	}
+	if sum != df.ClientCount {
+		log.Printf("WARNING: access points of group %d sum to %d, not %d, ignored",
+			df.GroupID, sum, df.ClientCount)
+		df.AccessPoints = nil
+	}
+}
+
+// insertAccessPoints adds the counts of every access point to the `ap_density`
+// table as part of the given transaction.
+func (data dataset) insertAccessPoints(txn *sql.Tx) error {
+	stmt, err := prepareAccessPointCopy(txn)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, d := range data {
+		if err = d.copyAccessPoints(stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		return fmt.Errorf("Failed to execute bulk insert => %s", err.Error())
+	}
+	return nil
+}
+
+// prepareAccessPointCopy starts a bulk insert to the `ap_density` table.
+func prepareAccessPointCopy(txn *sql.Tx) (*sql.Stmt, error) {
+	stmt, err := txn.Prepare(pq.CopyIn(
+		"ap_density",
+		"dump_time",
+		"group_id",
+		"ap_name",
+		"client_count",
+	))
+	if err != nil {
+		return nil, fmt.Errorf("Error prepping PG txn => %s", err.Error())
+	}
+	return stmt, nil
+}
+
+// copyAccessPoints adds the group's access points to a bulk insert started by
+// prepareAccessPointCopy.
+func (df dumpFormat) copyAccessPoints(stmt *sql.Stmt) error {
+	for _, ap := range df.AccessPoints {
+		if _, err := stmt.Exec(df.DumpTime, df.GroupID, ap.Name, ap.Clients); err != nil {
+			return fmt.Errorf("Failed to add to bulk insert => %s", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// group w/ access points, string-encoded and not
+var dataAPs = `{
+    "name" : "Butler Library 2",
+    "client_count" : 262,
+    "parent_id" : 103,
+    "access_points" : [
+        {"name" : "BUT-2-AP01", "client_count" : 140},
+        {"name" : "BUT-2-AP02", "client_count" : "122"}
+    ]
+}`
+
+// TestUnmarshalAccessPoints checks that the optional access points are parsed.
+func TestUnmarshalAccessPoints(t *testing.T) {
+	var d dumpFormat
+	if err := json.Unmarshal([]byte(dataAPs), &d); err != nil {
+		t.Fatalf("Failed to unmarshal data with access points => {%s}", err)
+	}
+
+	expected := []apCount{{Name: "BUT-2-AP01", Clients: 140}, {Name: "BUT-2-AP02", Clients: 122}}
+	if !reflect.DeepEqual(d.AccessPoints, expected) {
+		t.Errorf("Expected %#v, found %#v", expected, d.AccessPoints)
+	}
+
+	if err := json.Unmarshal([]byte(data1), &d); err != nil || d.AccessPoints != nil {
+		t.Errorf("Expected no access points, found %#v => %v", d.AccessPoints, err)
+	}
+}
+
+// TestValidateAccessPoints checks that access points not adding up are dropped.
+func TestValidateAccessPoints(t *testing.T) {
+	data := dataset{
+		{GroupID: 130, ClientCount: 262, AccessPoints: []apCount{{Name: "BUT-2-AP01", Clients: 140}, {Name: "BUT-2-AP02", Clients: 122}}},
+		{GroupID: 131, ClientCount: 200, AccessPoints: []apCount{{Name: "BUT-3-AP01", Clients: 174}}},
+	}
+	data.validateAccessPoints()
+
+	if len(data[0].AccessPoints) != 2 {
+		t.Errorf("Valid access points dropped, found %#v", data[0])
+	}
+	if data[1].AccessPoints != nil || data[1].ClientCount != 200 {
+		t.Errorf("Invalid access points kept, found %#v", data[1])
+	}
+}
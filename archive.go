@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// rawDump is an archived dump file, its contents are read from the archive with
+// open.
+type rawDump struct {
+	Source   string
+	DumpTime time.Time
+	Filename string
+	Hash     string
+	Size     int64
+	Chunked  bool // archived in `raw_dump_chunks` rather than as a whole
+}
+
+func init() {
+	commands["reparse"] = command{
+		description: "regenerate density data from the archived raw dumps, -from and -to limit the range",
+		run:         reparseCommand,
+	}
+}
+
+// hashContents is the hex encoded SHA-256 of a dump's contents.
+func hashContents(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// compress gzips the contents of a dump.
+func compress(contents []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(contents); err != nil {
+		return nil, fmt.Errorf("Failed to compress dump => {%s}", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to compress dump => {%s}", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress.
+func decompress(compressed []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress dump => {%s}", err)
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// archiveDump stores the compressed contents of a dump file in the `raw_dumps`
+// table, replacing any previous file for the same source and time.
+func archiveDump(db *sql.DB, src string, dumpTime time.Time, filename string, contents []byte) error {
+	compressed, err := compress(contents)
+	if err != nil {
+		return err
+	}
+
+	var (
+		name = path.Base(filename)
+		hash = hashContents(contents)
+	)
+	result, err := db.Exec(`UPDATE raw_dumps
+		SET filename = $3, sha256 = $4, size = $5, contents = $6, archived_at = now()
+		WHERE source = $1 AND dump_time = $2`,
+		src, dumpTime, name, hash, len(contents), compressed)
+	if err != nil {
+		return fmt.Errorf("Failed to update raw dump => {%s}", err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated > 0 {
+		return nil
+	}
+
+	_, err = db.Exec(`INSERT INTO raw_dumps (source, dump_time, filename, sha256, size, contents)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		src, dumpTime, name, hash, len(contents), compressed)
+	if err != nil {
+		return fmt.Errorf("Failed to insert raw dump => {%s}", err)
+	}
+	return nil
+}
+
+// rawChunkSize is the size, in bytes, of each compressed chunk of a dump archived
+// from its file.
+const rawChunkSize = 1 << 20
+
+// chunkWriter passes what's written to it to insert, a chunk of `size` bytes at a
+// time.
+type chunkWriter struct {
+	size   int
+	insert func(chunk int, contents []byte) error
+	chunk  int
+	buf    bytes.Buffer
+}
+
+// Write implements io.Writer, inserting every full chunk.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for w.buf.Len() >= w.size {
+		if err := w.flush(w.size); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close inserts whatever's left as the last chunk.
+func (w *chunkWriter) Close() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	return w.flush(w.buf.Len())
+}
+
+func (w *chunkWriter) flush(n int) error {
+	if err := w.insert(w.chunk, w.buf.Next(n)); err != nil {
+		return err
+	}
+	w.chunk++
+	return nil
+}
+
+// archiveFile stores a dump straight from its file, compressing it into chunks of
+// `raw_dump_chunks` so it's never held in memory, and replacing any previous file
+// for the same source and time.
+func archiveFile(db *sql.DB, src string, dumpTime time.Time, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to open file => %s", err.Error())
+	}
+	defer file.Close()
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+	if _, err = txn.Exec("DELETE FROM raw_dumps WHERE source = $1 AND dump_time = $2", src, dumpTime); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to remove raw dump => {%s}", err)
+	}
+	_, err = txn.Exec(`INSERT INTO raw_dumps (source, dump_time, filename, sha256, size)
+		VALUES ($1, $2, $3, '', 0)`, src, dumpTime, path.Base(filename))
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to insert raw dump => {%s}", err)
+	}
+
+	insert := func(chunk int, contents []byte) error {
+		_, err := txn.Exec(`INSERT INTO raw_dump_chunks (source, dump_time, chunk, contents)
+			VALUES ($1, $2, $3, $4)`, src, dumpTime, chunk, contents)
+		if err != nil {
+			return fmt.Errorf("Failed to insert chunk %d of raw dump => {%s}", chunk, err)
+		}
+		return nil
+	}
+	var (
+		chunks = &chunkWriter{size: rawChunkSize, insert: insert}
+		hash   = sha256.New()
+		writer = gzip.NewWriter(chunks)
+	)
+	size, err := io.Copy(writer, io.TeeReader(file, hash))
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = chunks.Close()
+	}
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to archive %s => %s", filename, err.Error())
+	}
+
+	_, err = txn.Exec(`UPDATE raw_dumps SET sha256 = $3, size = $4
+		WHERE source = $1 AND dump_time = $2`, src, dumpTime, hex.EncodeToString(hash.Sum(nil)), size)
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to update raw dump => {%s}", err)
+	}
+	return txn.Commit()
+}
+
+// rawReader reads the compressed contents of an archived dump, a chunk at a time.
+// next returns io.EOF once there are no chunks left.
+type rawReader struct {
+	next  func(chunk int) ([]byte, error)
+	chunk int
+	buf   []byte
+}
+
+// Read implements io.Reader.
+func (r *rawReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		contents, err := r.next(r.chunk)
+		if err != nil {
+			return 0, err
+		}
+		r.buf = contents
+		r.chunk++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// verifiedReader hashes the decompressed contents as they're read, failing at the
+// end if they don't match the hash they were archived with.
+type verifiedReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+	filename string
+}
+
+// Read implements io.Reader.
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if found := hex.EncodeToString(r.hash.Sum(nil)); found != r.expected {
+			return n, fmt.Errorf("Hash of %s is %s, expected %s", r.filename, found, r.expected)
+		}
+	}
+	return n, err
+}
+
+// newRawReader decompresses the chunks returned by next, checking them against the
+// hash.
+func newRawReader(filename, hash string, next func(chunk int) ([]byte, error)) (io.ReadCloser, error) {
+	reader, err := gzip.NewReader(&rawReader{next: next})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress %s => {%s}", filename, err)
+	}
+	return &verifiedReader{ReadCloser: reader, hash: sha256.New(), expected: hash, filename: filename}, nil
+}
+
+// chunk queries a chunk of the compressed contents. A dump that isn't chunked is
+// kept whole, as its only chunk.
+func (raw rawDump) chunk(db *sql.DB, chunk int) ([]byte, error) {
+	var (
+		contents []byte
+		err      error
+	)
+	switch {
+	case raw.Chunked:
+		err = db.QueryRow(`SELECT contents FROM raw_dump_chunks
+			WHERE source = $1 AND dump_time = $2 AND chunk = $3`,
+			raw.Source, raw.DumpTime, chunk).Scan(&contents)
+	case chunk == 0:
+		err = db.QueryRow("SELECT contents FROM raw_dumps WHERE source = $1 AND dump_time = $2",
+			raw.Source, raw.DumpTime).Scan(&contents)
+	default:
+		return nil, io.EOF
+	}
+	if err == sql.ErrNoRows {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to query chunk %d of %s => {%s}", chunk, raw.Filename, err)
+	}
+	return contents, nil
+}
+
+// open reads the contents of the dump from the archive, holding a chunk in memory at
+// a time.
+func (raw rawDump) open(db *sql.DB) opener {
+	return func() (io.ReadCloser, error) {
+		return newRawReader(raw.Filename, raw.Hash, func(chunk int) ([]byte, error) {
+			return raw.chunk(db, chunk)
+		})
+	}
+}
+
+// nextRawDump queries the first dump from the source archived within the range,
+// nil if there are none.
+func nextRawDump(db *sql.DB, src string, from, to time.Time) (*rawDump, error) {
+	var raw rawDump
+	err := db.QueryRow(`SELECT source, dump_time, filename, sha256, size, contents IS NULL
+		FROM raw_dumps
+		WHERE source = $1 AND dump_time >= $2 AND dump_time <= $3
+		ORDER BY dump_time
+		LIMIT 1`, src, from, to).Scan(&raw.Source, &raw.DumpTime, &raw.Filename, &raw.Hash, &raw.Size, &raw.Chunked)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to query raw dumps => {%s}", err)
+	}
+	return &raw, nil
+}
+
+// loadExcluded loads the groups whose counts were excluded from a dump as anomalous.
+func loadExcluded(db *sql.DB, dumpTime time.Time) (map[int]bool, error) {
+	rows, err := db.Query("SELECT group_id FROM density_data WHERE dump_time = $1 AND excluded", dumpTime)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query excluded groups => {%s}", err)
+	}
+	defer rows.Close()
+
+	excluded := make(map[int]bool)
+	for rows.Next() {
+		var groupID int
+		if err = rows.Scan(&groupID); err != nil {
+			return nil, fmt.Errorf("Failed to scan excluded group => {%s}", err)
+		}
+		excluded[groupID] = true
+	}
+	return excluded, rows.Err()
+}
+
+// deleteDumpData removes the density data of a dump, along with its access points
+// and breakdowns. density_data is partitioned, so nothing cascades to them.
+func deleteDumpData(txn *sql.Tx, dumpTime time.Time) error {
+	for _, table := range []string{"ap_density", "client_breakdown", "density_data"} {
+		if _, err := txn.Exec(fmt.Sprintf("DELETE FROM %s WHERE dump_time = $1", table), dumpTime); err != nil {
+			return fmt.Errorf("Failed to remove old %s => {%s}", table, err)
+		}
+	}
+	return nil
+}
+
+// reparseDump replaces the density data of an archived dump with the output of
+// the current parser, along with its rejects and the format it's recorded as.
+//
+// Counts that were excluded as anomalous stay excluded. A dump rejected by the
+// `invalidPolicy` keeps its previous density data.
+func reparseDump(db *sql.DB, raw rawDump, contents []byte) (ingestSummary, error) {
+	format, data, err := decodeFile(raw.Filename, raw.DumpTime, contents)
+	if err != nil {
+		return ingestSummary{}, err
+	}
+	data, rejects, invalid := data.validate(invalidPolicy)
+	excluded, err := loadExcluded(db, raw.DumpTime)
+	if err != nil {
+		return ingestSummary{}, err
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return ingestSummary{}, fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+	_, err = txn.Exec("DELETE FROM rejects WHERE source = $1 AND dump_time = $2", raw.Source, raw.DumpTime)
+	if err == nil {
+		err = insertRejectsTx(txn, raw.Source, raw.Filename, rejects)
+	}
+	if err != nil {
+		txn.Rollback()
+		return ingestSummary{}, err
+	}
+	if invalid != nil {
+		if err = txn.Commit(); err != nil {
+			return ingestSummary{}, fmt.Errorf("Failed to commit txn => %s", err.Error())
+		}
+		return ingestSummary{}, invalid
+	}
+
+	data.validateAccessPoints()
+	data.estimateOccupancy()
+	for i, d := range data {
+		data[i].Excluded = excluded[d.GroupID]
+	}
+	summary := data.summarize(format, nil, rejects)
+
+	if err = deleteDumpData(txn, raw.DumpTime); err == nil {
+		if err = data.insertTx(txn); err == nil {
+			err = updateDump(txn, raw.Source, raw.DumpTime, summary)
+		}
+	}
+	if err != nil {
+		txn.Rollback()
+		return ingestSummary{}, err
+	}
+	return summary, txn.Commit()
+}
+
+// reparseRaw reparses an archived dump, streaming it from the archive if it's as
+// large as the dumps that are streamed from their files.
+func reparseRaw(db *sql.DB, raw rawDump) (ingestSummary, error) {
+	open := raw.open(db)
+	if streamSize > 0 && raw.Size >= streamSize {
+		summary, err := streamDump(db, raw.Filename, open, raw.DumpTime, true)
+		if err != errNotStreamable {
+			return summary, err
+		}
+		log.Printf("WARNING: %s can't be streamed, reading it into memory", raw.Filename)
+	}
+
+	r, err := open()
+	if err != nil {
+		return ingestSummary{}, err
+	}
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return ingestSummary{}, fmt.Errorf("Failed to read %s => %s", raw.Filename, err.Error())
+	}
+	return reparseDump(db, raw, contents)
+}
+
+// reparseCommand regenerates the density data of every archived dump in the range,
+// a dump at a time.
+func reparseCommand(args []string) error {
+	var (
+		flags  = newFlagSet("reparse")
+		period = rangeFlags(flags)
+	)
+	flags.Parse(args)
+
+	start, end, err := period.parse()
+	if err != nil {
+		return err
+	}
+
+	db := dbConnect()
+	defer db.Close()
+	loadLookups(db)
+
+	for {
+		raw, err := nextRawDump(db, source, start, end)
+		if err != nil {
+			return err
+		} else if raw == nil {
+			break
+		}
+		start = raw.DumpTime.Add(time.Microsecond)
+
+		summary, err := reparseRaw(db, *raw)
+		if err != nil {
+			log.Printf("ERROR: Failed to reparse, %s => %s", raw.Filename, err.Error())
+			continue
+		}
+		if err = recordExtraKeys(db, raw.DumpTime, summary.ExtraKeys); err != nil {
+			log.Printf("ERROR: Failed to record extra keys from, %s => %s", raw.Filename, err.Error())
+		}
+		log.Printf("Reparsed %d groups from %s", summary.Rows, raw.Filename)
+	}
+
+	updateViews(db)
+	return nil
+}
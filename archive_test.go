@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// TestCompressRoundTrip checks that archived contents come back unchanged.
+func TestCompressRoundTrip(t *testing.T) {
+	compressed, err := compress([]byte(testingData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(testingData1) {
+		t.Errorf("Expected contents to shrink, %d bytes became %d", len(testingData1), len(compressed))
+	}
+
+	contents, err := decompress(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, []byte(testingData1)) {
+		t.Error("Decompressed contents don't match the original")
+	}
+
+	if _, err = decompress([]byte(testingData1)); err == nil {
+		t.Error("Expected error decompressing uncompressed contents")
+	}
+}
+
+// TestHashContents checks the SHA-256 of the contents.
+func TestHashContents(t *testing.T) {
+	expected := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if hash := hashContents(nil); hash != expected {
+		t.Errorf("Expected %s, found %s", expected, hash)
+	}
+}
+
+// TestChunkWriter checks that a dump compressed into chunks is put back together.
+func TestChunkWriter(t *testing.T) {
+	var chunks [][]byte
+	w := &chunkWriter{size: 64, insert: func(chunk int, contents []byte) error {
+		if chunk != len(chunks) {
+			t.Errorf("Expected chunk %d, found %d", len(chunks), chunk)
+		}
+		chunks = append(chunks, append([]byte(nil), contents...))
+		return nil
+	}}
+
+	writer := gzip.NewWriter(w)
+	if _, err := writer.Write([]byte(testingData1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chunks) < 2 {
+		t.Fatalf("Expected several chunks, found %d", len(chunks))
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) != 64 {
+			t.Errorf("Expected chunk %d to be 64 bytes, found %d", i, len(chunk))
+		}
+	}
+	contents, err := decompress(bytes.Join(chunks, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, []byte(testingData1)) {
+		t.Error("Chunked contents don't match the original")
+	}
+}
+
+// TestRawReader checks that an archived dump is read back a chunk at a time, and
+// that one that doesn't match its hash fails.
+func TestRawReader(t *testing.T) {
+	compressed, err := compress([]byte(testingData1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requested []int
+	next := func(chunk int) ([]byte, error) {
+		requested = append(requested, chunk)
+		start := chunk * 64
+		if start >= len(compressed) {
+			return nil, io.EOF
+		}
+		end := start + 64
+		if end > len(compressed) {
+			end = len(compressed)
+		}
+		return compressed[start:end], nil
+	}
+
+	r, err := newRawReader("2014-10-31-15-00.json", hashContents([]byte(testingData1)), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, []byte(testingData1)) {
+		t.Error("Chunked contents don't match the original")
+	}
+	for i, chunk := range requested {
+		if chunk != i {
+			t.Fatalf("Expected the chunks in order, found %v", requested)
+		}
+	}
+
+	requested = nil
+	r, err = newRawReader("2014-10-31-15-00.json", hashContents(nil), next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Error("Expected contents that don't match their hash to fail")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// breakdownFields maps the optional keys of a group's JSON to the dimension their
+// counts are stored under.
+var breakdownFields = map[string]string{
+	"bands":          "band",
+	"ssids":          "ssid",
+	"authentication": "auth",
+}
+
+// clientBreakdown is the part of a group's clients with a label in one dimension,
+// e.g. the clients on the band "5ghz" or the SSID "guest".
+type clientBreakdown struct {
+	Dimension string
+	Label     string
+	Clients   int
+}
+
+// breakdownField pulls the optional breakdowns that are among the fields out of a
+// group's generic map. Each is an object of labels to counts, which may be numbers
+// or string-encoded numbers.
+func breakdownField(raw map[string]interface{}, fields map[string]bool) ([]clientBreakdown, error) {
+	keys := make([]string, 0, len(breakdownFields))
+	for key := range breakdownFields {
+		if fields[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var breakdown []clientBreakdown
+	for _, key := range keys {
+		value, exists := raw[key]
+		if !exists || value == nil {
+			continue
+		}
+		counts, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Value in '%s' should be an object", key)
+		}
+
+		labels := make([]string, 0, len(counts))
+		for label := range counts {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			clients, err := intField(counts, label)
+			if err != nil {
+				return nil, fmt.Errorf("Breakdown '%s' => %s", key, err.Error())
+			}
+			breakdown = append(breakdown, clientBreakdown{
+				Dimension: breakdownFields[key],
+				Label:     label,
+				Clients:   clients,
+			})
+		}
+	}
+	return breakdown, nil
+}
+
+// insertBreakdowns adds the breakdown of every group to the `client_breakdown`
+// table as part of the given transaction.
+func (data dataset) insertBreakdowns(txn *sql.Tx) error {
+	stmt, err := prepareBreakdownCopy(txn)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, d := range data {
+		if err = d.copyBreakdown(stmt); err != nil {
+			return err
+		}
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		return fmt.Errorf("Failed to execute bulk insert => %s", err.Error())
+	}
+	return nil
+}
+
+// prepareBreakdownCopy starts a bulk insert to the `client_breakdown` table.
+func prepareBreakdownCopy(txn *sql.Tx) (*sql.Stmt, error) {
+	stmt, err := txn.Prepare(pq.CopyIn(
+		"client_breakdown",
+		"dump_time",
+		"group_id",
+		"dimension",
+		"label",
+		"client_count",
+	))
+	if err != nil {
+		return nil, fmt.Errorf("Error prepping PG txn => %s", err.Error())
+	}
+	return stmt, nil
+}
+
+// copyBreakdown adds the group's breakdown to a bulk insert started by
+// prepareBreakdownCopy.
+func (df dumpFormat) copyBreakdown(stmt *sql.Stmt) error {
+	for _, b := range df.Breakdown {
+		if _, err := stmt.Exec(df.DumpTime, df.GroupID, b.Dimension, b.Label, b.Clients); err != nil {
+			return fmt.Errorf("Failed to add to bulk insert => %s", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// group w/ breakdowns, string-encoded and not
+var dataBreakdown = `{
+    "name" : "Lerner 3",
+    "client_count" : 70,
+    "parent_id" : 84,
+    "bands" : {"5ghz" : 50, "2.4ghz" : "20"},
+    "ssids" : {"eduroam" : 60, "guest" : "10"},
+    "authentication" : {"authenticated" : 60, "guest" : 10}
+}`
+
+// TestUnmarshalBreakdown checks that the optional breakdowns are parsed.
+func TestUnmarshalBreakdown(t *testing.T) {
+	var d dumpFormat
+	if err := json.Unmarshal([]byte(dataBreakdown), &d); err != nil {
+		t.Fatalf("Failed to unmarshal data with breakdowns => {%s}", err)
+	}
+
+	expected := []clientBreakdown{
+		{"auth", "authenticated", 60},
+		{"auth", "guest", 10},
+		{"band", "2.4ghz", 20},
+		{"band", "5ghz", 50},
+		{"ssid", "eduroam", 60},
+		{"ssid", "guest", 10},
+	}
+	if !reflect.DeepEqual(d.Breakdown, expected) {
+		t.Errorf("Expected %#v, found %#v", expected, d.Breakdown)
+	}
+}
+
+// TestUnmarshalBadBreakdown checks that malformed breakdowns are noted as invalid.
+func TestUnmarshalBadBreakdown(t *testing.T) {
+	for _, bad := range []string{
+		`{"name" : "Lerner 3", "client_count" : 70, "parent_id" : 84, "bands" : [50, 20]}`,
+		`{"name" : "Lerner 3", "client_count" : 70, "parent_id" : 84, "ssids" : {"guest" : "n/a"}}`,
+	} {
+		var d dumpFormat
+		if err := json.Unmarshal([]byte(bad), &d); err != nil {
+			t.Errorf("Failed to unmarshal %s => %s", bad, err)
+		}
+		if len(d.Invalid) != 1 || d.Invalid[0].Field != "breakdown" {
+			t.Errorf("Expected an invalid breakdown for %s, found %#v", bad, d.Invalid)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// capacity is the size of a group's space from a given date onwards.
+// DevicesPerPerson is the average number of connected devices a person carries.
+type capacity struct {
+	EffectiveDate    time.Time
+	Seats            int
+	DevicesPerPerson float64
+}
+
+// capacities holds every known capacity per group ID, sorted by effective date.
+// It is loaded from the `group_capacity` table.
+var capacities = map[int][]capacity{}
+
+// loadCapacities refreshes `capacities` from the database.
+func loadCapacities(db *sql.DB) error {
+	rows, err := db.Query(`SELECT group_id, effective_date, seats, devices_per_person
+		FROM group_capacity
+		ORDER BY group_id, effective_date`)
+	if err != nil {
+		return fmt.Errorf("Failed to query capacities => {%s}", err)
+	}
+	defer rows.Close()
+
+	loaded := make(map[int][]capacity)
+	for rows.Next() {
+		var (
+			id int
+			c  capacity
+		)
+		if err = rows.Scan(&id, &c.EffectiveDate, &c.Seats, &c.DevicesPerPerson); err != nil {
+			return fmt.Errorf("Failed to scan capacity => {%s}", err)
+		}
+		// dates come back as UTC midnight, they are meant as NY dates
+		y, m, d := c.EffectiveDate.Date()
+		c.EffectiveDate = time.Date(y, m, d, 0, 0, 0, 0, NY)
+		loaded[id] = append(loaded[id], c)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("Failed to read capacities => {%s}", err)
+	}
+
+	capacities = loaded
+	return nil
+}
+
+// capacityAt finds the capacity of a group in effect at the given time.
+func capacityAt(groupID int, t time.Time) (capacity, bool) {
+	history := capacities[groupID]
+	// index of the first capacity that takes effect after t
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].EffectiveDate.After(t)
+	})
+	if i == 0 {
+		return capacity{}, false
+	}
+	return history[i-1], true
+}
+
+// estimateOccupancy fills in the estimated number of people and how full each
+// group is for every group with a known capacity.
+func (data dataset) estimateOccupancy() {
+	for i := range data {
+		data[i].estimateOccupancy()
+	}
+}
+
+// estimateOccupancy fills in the estimated number of people and how full the group
+// is, if its capacity and count are known.
+func (df *dumpFormat) estimateOccupancy() {
+	c, exists := capacityAt(df.GroupID, df.DumpTime)
+	if !exists || df.NullCount {
+		return
+	}
+
+	perPerson := c.DevicesPerPerson
+	if perPerson <= 0 {
+		perPerson = 1
+	}
+	occupancy := float64(df.ClientCount) / perPerson
+	df.EstimatedOccupancy = sql.NullFloat64{Float64: occupancy, Valid: true}
+
+	if c.Seats > 0 {
+		percent := occupancy / float64(c.Seats) * 100
+		df.PercentFull = sql.NullFloat64{Float64: percent, Valid: true}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestEstimateOccupancy checks that the capacity in effect at the dump time is used.
+func TestEstimateOccupancy(t *testing.T) {
+	capacities = map[int][]capacity{
+		130: {
+			{EffectiveDate: time.Date(2014, time.January, 1, 0, 0, 0, 0, NY), Seats: 500, DevicesPerPerson: 2},
+			{EffectiveDate: time.Date(2014, time.October, 1, 0, 0, 0, 0, NY), Seats: 200, DevicesPerPerson: 1.5},
+			{EffectiveDate: time.Date(2015, time.January, 1, 0, 0, 0, 0, NY), Seats: 100, DevicesPerPerson: 1},
+		},
+		152: {
+			{EffectiveDate: time.Date(2014, time.January, 1, 0, 0, 0, 0, NY), Seats: 0, DevicesPerPerson: 0},
+		},
+	}
+	defer func() { capacities = map[int][]capacity{} }()
+
+	dumpTime := time.Date(2014, time.October, 31, 15, 0, 0, 0, NY)
+	data := dataset{
+		{DumpTime: dumpTime, GroupID: 130, ClientCount: 240},
+		{DumpTime: dumpTime, GroupID: 152, ClientCount: 70},
+		{DumpTime: dumpTime, GroupID: 155, ClientCount: 90},
+	}
+	data.estimateOccupancy()
+
+	if o := data[0].EstimatedOccupancy; !o.Valid || o.Float64 != 160 {
+		t.Errorf("Expected occupancy of 160, found %#v", o)
+	}
+	if p := data[0].PercentFull; !p.Valid || p.Float64 != 80 {
+		t.Errorf("Expected 80%% full, found %#v", p)
+	}
+
+	// no seats means no percentage, but still an occupancy
+	if o := data[1].EstimatedOccupancy; !o.Valid || o.Float64 != 70 {
+		t.Errorf("Expected occupancy of 70, found %#v", o)
+	}
+	if data[1].PercentFull.Valid {
+		t.Errorf("Expected no percentage without seats, found %#v", data[1].PercentFull)
+	}
+
+	if data[2].EstimatedOccupancy.Valid || data[2].PercentFull.Valid {
+		t.Errorf("Expected nothing for group without capacity, found %#v", data[2])
+	}
+}
+
+// TestCapacityBeforeEffective checks that capacities don't apply before they take effect.
+func TestCapacityBeforeEffective(t *testing.T) {
+	capacities = map[int][]capacity{
+		130: {{EffectiveDate: time.Date(2015, time.January, 1, 0, 0, 0, 0, NY), Seats: 100}},
+	}
+	defer func() { capacities = map[int][]capacity{} }()
+
+	if _, exists := capacityAt(130, time.Date(2014, time.December, 31, 23, 45, 0, 0, NY)); exists {
+		t.Error("Capacity applied before its effective date")
+	}
+	if _, exists := capacityAt(130, time.Date(2015, time.January, 1, 0, 0, 0, 0, NY)); !exists {
+		t.Error("Capacity not applied on its effective date")
+	}
+}
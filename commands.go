@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// command is run in place of loading and watching files when its name is given
+// after the flags.
+type command struct {
+	description string
+	run         func(args []string) error
+}
+
+// commands lists every command by name.
+var commands = map[string]command{}
+
+// runCommand runs the named command with the remaining arguments, exiting if it fails.
+func runCommand(name string, args []string) {
+	cmd, exists := commands[name]
+	if !exists {
+		log.Printf("ERROR: Unknown command, %s", name)
+		printCommands()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(args); err != nil {
+		log.Fatalf("ERROR: %s failed => %s", name, err.Error())
+	}
+}
+
+// printCommands writes the name and description of every command to stderr.
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", name, commands[name].description)
+	}
+}
+
+// newFlagSet creates the flags for a command, exiting on parse errors.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet(name, flag.ExitOnError)
+}
+
+// parseTimeFlag parses a time given to a command in NY time. Either the filename
+// format or a plain date is accepted.
+func parseTimeFlag(value string) (time.Time, error) {
+	for _, format := range []string{datetimeFormat, "2006-01-02"} {
+		if tm, err := time.ParseInLocation(format, value, NY); err == nil {
+			return tm, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid time, %s, expected YYYY-MM-DD or %s", value, datetimeFormat)
+}
+
+// timeRange holds the -from and -to flags of a command.
+type timeRange struct {
+	from, to *string
+}
+
+// rangeFlags adds the -from and -to flags to a command.
+func rangeFlags(flags *flag.FlagSet) timeRange {
+	return timeRange{
+		from: flags.String("from", "", "start of the range, YYYY-MM-DD or YYYY-MM-DD-HH-MM (default: the beginning)"),
+		to:   flags.String("to", "", "end of the range, YYYY-MM-DD or YYYY-MM-DD-HH-MM (default: now)"),
+	}
+}
+
+// parse converts the flags to times, defaulting to everything up until now.
+func (r timeRange) parse() (start, end time.Time, err error) {
+	end = time.Now()
+	if *r.from != "" {
+		if start, err = parseTimeFlag(*r.from); err != nil {
+			return
+		}
+	}
+	if *r.to != "" {
+		end, err = parseTimeFlag(*r.to)
+	}
+	return
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// statuses of a group in the `dump_coverage` table
+const (
+	coverageMissing = "missing"
+	coverageNew     = "new"
+)
+
+// coverage records a group that went missing from, or newly appeared in, a dump.
+// MissingIntervals is the number of consecutive dumps a missing group has been absent.
+type coverage struct {
+	DumpTime         time.Time
+	GroupID          int
+	Status           string
+	MissingIntervals int
+}
+
+var (
+	// coverageWindow is how far back a group must have been seen to be expected in a dump.
+	coverageWindow = 24 * time.Hour
+	// missingWarnIntervals is the number of consecutive dumps a group can be missing
+	// before a warning is raised.
+	missingWarnIntervals = 4
+)
+
+// compareCoverage compares the groups present in a dump against the recently known
+// groups.
+//
+// `previouslyMissing` holds the number of intervals each group was already missing
+// for as of the previous dump.
+func compareCoverage(dumpTime time.Time, present, known map[int]bool, previouslyMissing map[int]int) []coverage {
+	// nothing is known before the first dump, so nothing can be new or missing
+	if len(known) == 0 {
+		return nil
+	}
+
+	var changes []coverage
+	for id := range known {
+		if !present[id] {
+			changes = append(changes, coverage{
+				DumpTime:         dumpTime,
+				GroupID:          id,
+				Status:           coverageMissing,
+				MissingIntervals: previouslyMissing[id] + 1,
+			})
+		}
+	}
+	for id := range present {
+		if !known[id] {
+			changes = append(changes, coverage{
+				DumpTime: dumpTime,
+				GroupID:  id,
+				Status:   coverageNew,
+			})
+		}
+	}
+
+	sort.Sort(byGroupID(changes))
+	return changes
+}
+
+// byGroupID sorts coverage changes by their group ID.
+type byGroupID []coverage
+
+func (c byGroupID) Len() int           { return len(c) }
+func (c byGroupID) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byGroupID) Less(i, j int) bool { return c[i].GroupID < c[j].GroupID }
+
+// groupIDs is the set of groups in the dataset.
+func (data dataset) groupIDs() map[int]bool {
+	present := make(map[int]bool, len(data))
+	for _, d := range data {
+		present[d.GroupID] = true
+	}
+	return present
+}
+
+// checkCoverage loads the recently known groups from the database, records which
+// groups are missing or new in the dump and warns about long missing groups.
+func checkCoverage(db *sql.DB, dumpTime time.Time, present map[int]bool) error {
+	known, err := loadKnownGroups(db, dumpTime)
+	if err != nil {
+		return err
+	}
+
+	previouslyMissing, err := loadPreviouslyMissing(db, dumpTime)
+	if err != nil {
+		return err
+	}
+
+	changes := compareCoverage(dumpTime, present, known, previouslyMissing)
+	for _, c := range changes {
+		if c.Status == coverageMissing && c.MissingIntervals >= missingWarnIntervals {
+			log.Printf("WARNING: group %d has been missing for %d dumps as of %s",
+				c.GroupID, c.MissingIntervals, dumpTime)
+		}
+	}
+
+	return insertCoverage(db, changes)
+}
+
+// loadDumpGroups gets the groups written for a dump.
+func loadDumpGroups(db *sql.DB, dumpTime time.Time) (map[int]bool, error) {
+	rows, err := db.Query("SELECT group_id FROM density_data WHERE dump_time = $1", dumpTime)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query groups => {%s}", err)
+	}
+	defer rows.Close()
+
+	present := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("Failed to scan group => {%s}", err)
+		}
+		present[id] = true
+	}
+	return present, rows.Err()
+}
+
+// loadKnownGroups gets every group seen within the `coverageWindow` before the dump.
+func loadKnownGroups(db *sql.DB, dumpTime time.Time) (map[int]bool, error) {
+	rows, err := db.Query(`SELECT DISTINCT group_id
+		FROM density_data
+		WHERE dump_time < $1 AND dump_time >= $2`, dumpTime, dumpTime.Add(-coverageWindow))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query known groups => {%s}", err)
+	}
+	defer rows.Close()
+
+	known := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("Failed to scan known group => {%s}", err)
+		}
+		known[id] = true
+	}
+	return known, rows.Err()
+}
+
+// loadPreviouslyMissing gets the groups missing from the previous dump and for how
+// many intervals they had been missing.
+func loadPreviouslyMissing(db *sql.DB, dumpTime time.Time) (map[int]int, error) {
+	rows, err := db.Query(`SELECT group_id, missing_intervals
+		FROM dump_coverage
+		WHERE status = $1 AND dump_time = (
+			SELECT MAX(dump_time) FROM density_data WHERE dump_time < $2
+		)`, coverageMissing, dumpTime)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query missing groups => {%s}", err)
+	}
+	return scanCounts(rows)
+}
+
+// insertCoverage records the coverage changes in the `dump_coverage` table.
+func insertCoverage(db *sql.DB, changes []coverage) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	stmt, err := txn.Prepare(`INSERT INTO dump_coverage
+		(dump_time, group_id, status, missing_intervals)
+		VALUES ($1, $2, $3, $4)`)
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Error prepping PG txn => %s", err.Error())
+	}
+	defer stmt.Close()
+
+	for _, c := range changes {
+		if _, err = stmt.Exec(c.DumpTime, c.GroupID, c.Status, c.MissingIntervals); err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to insert coverage => %s", err.Error())
+		}
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCompareCoverage checks that missing and new groups are found.
+func TestCompareCoverage(t *testing.T) {
+	dumpTime := time.Date(2014, time.October, 31, 15, 0, 0, 0, NY)
+	data := dataset{{GroupID: 130}, {GroupID: 131}, {GroupID: 200}}
+	known := map[int]bool{130: true, 131: true, 152: true, 155: true}
+	previouslyMissing := map[int]int{155: 3}
+
+	changes := compareCoverage(dumpTime, data.groupIDs(), known, previouslyMissing)
+	expected := []coverage{
+		{DumpTime: dumpTime, GroupID: 152, Status: coverageMissing, MissingIntervals: 1},
+		{DumpTime: dumpTime, GroupID: 155, Status: coverageMissing, MissingIntervals: 4},
+		{DumpTime: dumpTime, GroupID: 200, Status: coverageNew},
+	}
+
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, found %#v", len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected %#v, found %#v", expected[i], changes[i])
+		}
+	}
+}
+
+// TestCompareCoverageFirstDump checks that nothing is reported without known groups.
+func TestCompareCoverageFirstDump(t *testing.T) {
+	data := dataset{{GroupID: 130}}
+	if changes := compareCoverage(time.Time{}, data.groupIDs(), map[int]bool{}, nil); len(changes) != 0 {
+		t.Errorf("Expected no changes, found %#v", changes)
+	}
+}
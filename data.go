@@ -30,75 +30,158 @@ type dataset []dumpFormat
 // DumpTime, GroupID, & ParentName are gathered from the dumped JSON file.
 // GroupName, ParentID, & ClientCount are configured based on the filename and
 // JSON format.
+// The embedded groupLocation is derived from the group name by the `nameRules`.
+// EstimatedOccupancy & PercentFull are derived from the group's capacity, if known.
+// Excluded marks anomalous counts that should be left out of the rollups.
+// AccessPoints optionally breaks the ClientCount down per access point, and
+// Breakdown by band, SSID and authentication.
+// Extras holds any keys of the group's JSON that aren't recognized.
+// Version is the format version the record was parsed in, zero if unversioned.
+// NullCount marks a ClientCount that was invalid and is stored as null.
+// Invalid lists every field that failed to parse, along with the Raw record.
 type dumpFormat struct {
-	DumpTime    time.Time
-	GroupID     int
-	ParentName  string
-	GroupName   string
-	ParentID    int
-	ClientCount int
+	DumpTime           time.Time
+	GroupID            int
+	ParentName         string
+	GroupName          string
+	ParentID           int
+	ClientCount        int
+	EstimatedOccupancy sql.NullFloat64
+	PercentFull        sql.NullFloat64
+	Excluded           bool
+	AccessPoints       []apCount
+	Breakdown          []clientBreakdown
+	Extras             map[string]interface{}
+	Version            int
+	NullCount          bool
+	Invalid            []fieldError
+	Raw                string
+	groupLocation
 }
 
 // UnmarshalJSON inmplements JSON's Unmarshaler interface.
 // This allows us to deal with inconsistent number encoding in the 'parent_id' and
 // 'client_count' fields.
+//
+// Invalid fields don't stop the rest of the dump from parsing, every problem is
+// noted in Invalid instead. Records are parsed in the current format version.
 func (df *dumpFormat) UnmarshalJSON(data []byte) error {
+	df.parseRecord(data, currentVersion, false)
+	return nil
+}
+
+// parseRecord parses a record in the given format version.
+//
+// If the version was declared by the dump it's held to it, otherwise the record is
+// parsed in the given version and fingerprinted to find the version it fits.
+func (df *dumpFormat) parseRecord(data []byte, v formatVersion, declared bool) {
+	defer func() { df.keepRaw(string(data)) }()
+
 	// pull data into a generic map
 	raw := make(map[string]interface{})
 	err := json.Unmarshal(data, &raw)
 	if err != nil {
-		return fmt.Errorf("Failed to unpack data into map => {%s}", err)
+		df.invalidate("record", fmt.Errorf("Failed to unpack data into map => {%s}", err))
+		return
 	}
 
 	// get name and insure it's a string
 	name, exists := raw["name"]
-	if !exists {
-		return fmt.Errorf("key 'name' missing ")
-	}
 	switch n := name.(type) {
 	case string:
 		df.GroupName = n
 	default:
-		return fmt.Errorf("Value in 'name' should be string")
+		if !exists {
+			df.invalidate("name", fmt.Errorf("key 'name' missing "))
+		} else {
+			df.invalidate("name", fmt.Errorf("Value in 'name' should be string"))
+		}
 	}
 
-	// get parent_id and take either int or string
-	pID, exists := raw["parent_id"]
-	if !exists {
-		return fmt.Errorf("key 'parent_id' missing ")
+	// get parent_id and client_count, taking either int or string if the version allows
+	var stringNumbers bool
+	df.ParentID = df.numberField(raw, "parent_id", v, &stringNumbers)
+	df.ClientCount = df.numberField(raw, "client_count", v, &stringNumbers)
+
+	// get the optional per access point counts
+	if v.Fields["access_points"] {
+		if df.AccessPoints, err = accessPointsField(raw); err != nil {
+			df.invalidate("access_points", err)
+		}
 	}
-	switch p := pID.(type) {
-	case string:
-		i, err := strconv.Atoi(p)
-		if err != nil {
-			return fmt.Errorf("Failed to convert 'parent_id', %s, to int => {%s}", p, err)
+
+	// get the optional breakdowns and take either int or string
+	if df.Breakdown, err = breakdownField(raw, v.Fields); err != nil {
+		df.invalidate("breakdown", err)
+	}
+
+	// keep anything else that was sent
+	df.Extras = extrasField(raw, v.Fields)
+
+	df.Version = v.Version
+	if !declared {
+		df.Version = fingerprint(raw, stringNumbers)
+	}
+}
+
+// numberField pulls a number out of a record's generic map, noting whether it was
+// string-encoded and invalidating it if the version doesn't allow that.
+func (df *dumpFormat) numberField(raw map[string]interface{}, key string, v formatVersion, stringNumbers *bool) int {
+	n, err := intField(raw, key)
+	if err != nil {
+		df.invalidate(key, err)
+		return 0
+	}
+	if _, isString := raw[key].(string); isString {
+		*stringNumbers = true
+		if !v.StringNumbers {
+			df.invalidate(key, fmt.Errorf("Value in '%s' should be a number in format version %d", key, v.Version))
 		}
-		df.ParentID = i
-	case float64:
-		df.ParentID = int(p)
-	default:
-		return fmt.Errorf("Value in 'parent_id', %#v, should be int or string", p)
 	}
+	return n
+}
 
-	// get client_count and take either int or string
-	cc, exists := raw["client_count"]
+// intField pulls an integer out of a generic map, taking either a number or a
+// string-encoded number.
+func intField(raw map[string]interface{}, key string) (int, error) {
+	value, exists := raw[key]
 	if !exists {
-		return fmt.Errorf("key 'client_count' missing ")
+		return 0, fmt.Errorf("key '%s' missing ", key)
 	}
-	switch c := cc.(type) {
+	switch v := value.(type) {
 	case string:
-		i, err := strconv.Atoi(c)
+		i, err := strconv.Atoi(v)
 		if err != nil {
-			return fmt.Errorf("Failed to convert 'client_count', %s, to int => {%s}", c, err)
+			return 0, fmt.Errorf("Failed to convert '%s', %s, to int => {%s}", key, v, err)
 		}
-		df.ClientCount = i
+		return i, nil
 	case float64:
-		df.ClientCount = int(c)
+		return int(v), nil
 	default:
-		return fmt.Errorf("Value in 'client_count' should be int or string")
+		return 0, fmt.Errorf("Value in '%s', %#v, should be int or string", key, v)
 	}
+}
 
-	return nil
+// annotate adds the fields that aren't part of the dump itself to a record.
+//
+// adds:
+// - a timestamp based on the filename
+// - a parent name based on the parentNameLookup table
+// - a floor, wing and zone based on the nameRules and nameOverrides
+func (df *dumpFormat) annotate(timestamp time.Time) {
+	df.DumpTime = timestamp
+
+	// there's nothing to look up if the group can't be identified
+	if !df.nullable() {
+		return
+	}
+
+	var exists bool
+	if df.ParentName, exists = parentNameLookup[df.ParentID]; !exists {
+		log.Printf("ERROR: no parent name for %d exists in group: %d", df.ParentID, df.GroupID)
+	}
+
+	df.groupLocation, _ = locateGroup(df.GroupID, df.ParentID, df.GroupName)
 }
 
 // parseData unmarshals a byte array into an array of wireless data dumps.
@@ -106,39 +189,62 @@ func (df *dumpFormat) UnmarshalJSON(data []byte) error {
 // This is a little more complicated because the group ID is stored as the key to the
 // remainder of the data for the record.
 //
-// adds:
-// - a timestamp based on the filename
-// - a group ID based on the group's key in the JSON
-// - a parent name based on the parentNameLookup table
+// adds a group ID based on the group's key in the JSON, then annotates the record.
+// If the dump declares its format version every group is parsed in it.
 func parseData(timestamp time.Time, datafile []byte) (dataset, error) {
 	// marshal what data we can from the json
-	parsed := make(map[string]dumpFormat)
+	parsed := make(map[string]json.RawMessage)
 	if err := json.Unmarshal(datafile, &parsed); err != nil {
 		return []dumpFormat{}, fmt.Errorf("Error parsing bytes => %s", err.Error())
 	}
 
-	var (
-		data   = make([]dumpFormat, len(parsed))
-		i      int
-		err    error
-		exists bool
-	)
+	v, declared, err := declaredVersion(parsed)
+	if err != nil {
+		return []dumpFormat{}, err
+	}
+
+	data := make([]dumpFormat, 0, len(parsed))
 	// add all fields needed to the JSON
-	for id, d := range parsed {
-		if d.GroupID, err = strconv.Atoi(id); err != nil {
-			return []dumpFormat{}, fmt.Errorf("ERR: Failed to parse int, %s => %s", id, err.Error())
-		}
+	for id, raw := range parsed {
+		data = append(data, parseGroup(timestamp, id, raw, v, declared))
+	}
 
-		d.DumpTime = timestamp
+	return data, nil
+}
 
-		if d.ParentName, exists = parentNameLookup[d.ParentID]; !exists {
-			log.Printf("ERROR: no parent name for %d exists in group: %d", d.ParentID, d.GroupID)
-		}
-		data[i] = d
-		i++
+// declaredVersion pulls the format version out of a dump's groups, if declared,
+// otherwise the current version is used.
+func declaredVersion(parsed map[string]json.RawMessage) (formatVersion, bool, error) {
+	header, exists := parsed[versionKey]
+	if !exists {
+		return currentVersion, false, nil
 	}
+	delete(parsed, versionKey)
 
-	return data, nil
+	var value interface{}
+	if err := json.Unmarshal(header, &value); err != nil {
+		return formatVersion{}, false, fmt.Errorf("Error parsing %s => %s", versionKey, err.Error())
+	}
+	v, err := parseVersion(value)
+	return v, true, err
+}
+
+// parseGroup unmarshals a single group keyed by its ID in the given format version,
+// then annotates it.
+func parseGroup(timestamp time.Time, id string, raw []byte, v formatVersion, declared bool) dumpFormat {
+	var (
+		d   dumpFormat
+		err error
+	)
+	// invalid fields are noted rather than failing
+	d.parseRecord(raw, v, declared)
+	if d.GroupID, err = strconv.Atoi(id); err != nil {
+		d.invalidate("group_id", fmt.Errorf("Failed to parse int, %s => %s", id, err.Error()))
+		d.keepRaw(string(raw))
+	}
+
+	d.annotate(timestamp)
+	return d
 }
 
 // insert operates on a list of dumpFormat and inserts them to the provided Postgres
@@ -149,6 +255,73 @@ func (data dataset) insert(db *sql.DB) error {
 		return fmt.Errorf("Error starting PG txn => %s", err.Error())
 	}
 
+	if err = data.insertTx(transaction); err != nil {
+		transaction.Rollback()
+		return err
+	}
+
+	// commit the transaction if there's been no errors
+	if err = transaction.Commit(); err != nil {
+		log.Printf("ERROR: Failed to commit txn => %s", err.Error())
+		if err = transaction.Rollback(); err != nil {
+			log.Printf("ERROR: Failed to rollback txn => %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// insertTx inserts the dataset, along with its access points and breakdowns, as
+// part of the given transaction.
+func (data dataset) insertTx(transaction *sql.Tx) error {
+	if len(data) > 0 {
+		if err := ensurePartitions(transaction, data[0].DumpTime); err != nil {
+			return err
+		}
+		if err := checkDownsampled(transaction, data[0].DumpTime); err != nil {
+			return err
+		}
+	}
+
+	stmt, err := prepareDensityCopy(transaction)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// Add all data from the set
+	for _, d := range data {
+		if err = d.copyRow(stmt); err != nil {
+			return err
+		}
+	}
+
+	// execute the transaction
+	if _, err = stmt.Exec(); err != nil {
+		return fmt.Errorf("Failed to execute bulk insert => %s", err.Error())
+	}
+	stmt.Close()
+
+	// add the access points and breakdowns of every group that has them
+	var hasAccessPoints, hasBreakdown bool
+	for _, d := range data {
+		hasAccessPoints = hasAccessPoints || len(d.AccessPoints) > 0
+		hasBreakdown = hasBreakdown || len(d.Breakdown) > 0
+	}
+	if hasAccessPoints {
+		if err = data.insertAccessPoints(transaction); err != nil {
+			return err
+		}
+	}
+	if hasBreakdown {
+		if err = data.insertBreakdowns(transaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareDensityCopy starts a bulk insert to the `density_data` table.
+func prepareDensityCopy(transaction *sql.Tx) (*sql.Stmt, error) {
 	// PG's COPY FROM used for fast mass insertions. Syntax is table followed by columns.
 	// http://godoc.org/github.com/lib/pq#hdr-Bulk_imports
 	stmt, err := transaction.Prepare(pq.CopyIn(
@@ -159,38 +332,85 @@ func (data dataset) insert(db *sql.DB) error {
 		"parent_id",
 		"parent_name",
 		"client_count",
+		"floor",
+		"wing",
+		"zone",
+		"estimated_occupancy",
+		"percent_full",
+		"excluded",
+		"extras",
 	))
 	if err != nil {
-		return fmt.Errorf("Error prepping PG txn => %s", err.Error())
+		return nil, fmt.Errorf("Error prepping PG txn => %s", err.Error())
 	}
-	defer stmt.Close()
+	return stmt, nil
+}
 
-	// Add all data from the set
-	for _, d := range data {
-		_, err = stmt.Exec(
-			d.DumpTime,
-			d.GroupID,
-			d.GroupName,
-			d.ParentID,
-			d.ParentName,
-			d.ClientCount,
-		)
-		if err != nil {
-			return fmt.Errorf("Failed to add to bulk insert => %s", err.Error())
-		}
+// copyRow adds the record to a bulk insert started by prepareDensityCopy.
+func (df dumpFormat) copyRow(stmt *sql.Stmt) error {
+	extras, err := df.extrasJSON()
+	if err != nil {
+		return err
 	}
 
-	// execute the transaction
-	if _, err = stmt.Exec(); err != nil {
-		return fmt.Errorf("Failed to execute bulk insert => %s", err.Error())
+	_, err = stmt.Exec(
+		df.DumpTime,
+		df.GroupID,
+		df.GroupName,
+		df.ParentID,
+		df.ParentName,
+		sql.NullInt64{Int64: int64(df.ClientCount), Valid: !df.NullCount},
+		df.Floor,
+		df.Wing,
+		df.Zone,
+		df.EstimatedOccupancy,
+		df.PercentFull,
+		df.Excluded,
+		extras,
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to add to bulk insert => %s", err.Error())
 	}
+	return nil
+}
 
-	// commit the transaction if there's been no errors
-	if err = transaction.Commit(); err != nil {
-		log.Printf("ERROR: Failed to commit txn => %s", err.Error())
-		if err = transaction.Rollback(); err != nil {
-			log.Printf("ERROR: Failed to rollback txn => %s", err.Error())
+// loadDataset reads every group stored for a single dump time.
+func loadDataset(db *sql.DB, dumpTime time.Time) (dataset, error) {
+	rows, err := db.Query(`SELECT
+			dump_time, group_id, group_name, parent_id, parent_name, client_count,
+			floor, wing, zone, estimated_occupancy, percent_full, excluded
+		FROM density_data
+		WHERE dump_time = $1`, dumpTime)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query dataset => %s", err.Error())
+	}
+	defer rows.Close()
+
+	var data dataset
+	for rows.Next() {
+		var (
+			d     dumpFormat
+			count sql.NullInt64
+		)
+		err = rows.Scan(
+			&d.DumpTime,
+			&d.GroupID,
+			&d.GroupName,
+			&d.ParentID,
+			&d.ParentName,
+			&count,
+			&d.Floor,
+			&d.Wing,
+			&d.Zone,
+			&d.EstimatedOccupancy,
+			&d.PercentFull,
+			&d.Excluded,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan dataset => %s", err.Error())
 		}
+		d.ClientCount, d.NullCount = int(count.Int64), !count.Valid
+		data = append(data, d)
 	}
-	return nil
+	return data, rows.Err()
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -94,18 +96,20 @@ var testingData2 = `{
 
 var expectedData = []dumpFormat{
 	{
-		GroupID:     152,
-		GroupName:   "Lerner 3",
-		ClientCount: 70,
-		ParentID:    84,
-		ParentName:  "Lerner",
+		GroupID:       152,
+		GroupName:     "Lerner 3",
+		ClientCount:   70,
+		ParentID:      84,
+		ParentName:    "Lerner",
+		groupLocation: groupLocation{Floor: sql.NullInt64{Int64: 3, Valid: true}},
 	},
 	{
-		GroupID:     131,
-		GroupName:   "Butler Library 3",
-		ClientCount: 328,
-		ParentID:    103,
-		ParentName:  "Butler",
+		GroupID:       131,
+		GroupName:     "Butler Library 3",
+		ClientCount:   328,
+		ParentID:      103,
+		ParentName:    "Butler",
+		groupLocation: groupLocation{Floor: sql.NullInt64{Int64: 3, Valid: true}},
 	},
 	{
 		GroupID:     155,
@@ -115,27 +119,31 @@ var expectedData = []dumpFormat{
 		ParentName:  "John Jay",
 	},
 	{
-		GroupID:     130,
-		GroupName:   "Butler Library 2",
-		ClientCount: 412,
-		ParentID:    103,
-		ParentName:  "Butler",
+		GroupID:       130,
+		GroupName:     "Butler Library 2",
+		ClientCount:   412,
+		ParentID:      103,
+		ParentName:    "Butler",
+		groupLocation: groupLocation{Floor: sql.NullInt64{Int64: 2, Valid: true}},
 	},
 }
 
 // TestParseData parses the raw data in `testingData` and confirms that it
 // correctly configures all data fields.
 func TestParseData(t *testing.T) {
-	for _, dataset := range []string{testingData1, testingData2} {
+	for i, dataset := range []string{testingData1, testingData2} {
 		data, err := parseData(time.Time{}, []byte(dataset))
 		if err != nil {
 			t.Fatal(err)
 		}
+		if version := data.formatVersion(); version != i+1 {
+			t.Errorf("Expected format version %d, found %d", i+1, version)
+		}
 
 		// make sure that the parsed data is in the expected data
 		expected := func(d dumpFormat, t *testing.T) {
 			for _, e := range expectedData {
-				if d == e {
+				if reflect.DeepEqual(d, e) {
 					return
 				}
 			}
@@ -144,6 +152,7 @@ func TestParseData(t *testing.T) {
 
 		// n^2 because n == 4....
 		for _, d := range data {
+			d.Version = 0
 			expected(d, t)
 		}
 	}
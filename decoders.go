@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Decoder turns the contents of a dump file into a dataset.
+type Decoder interface {
+	// Sniff reports whether the contents look like they're in the decoder's format.
+	Sniff(contents []byte) bool
+	// Decode parses every record from the contents and annotates them with the timestamp.
+	Decode(timestamp time.Time, contents []byte) (dataset, error)
+}
+
+// registeredDecoder is a Decoder along with the file extensions it handles.
+type registeredDecoder struct {
+	name       string
+	decoder    Decoder
+	extensions []string
+}
+
+// decoders are tried in order when sniffing the contents of a file.
+var decoders = []registeredDecoder{
+	{"cuit", objectDecoder{}, []string{".json"}},
+	{"array", arrayDecoder{}, []string{".json"}},
+	{"ndjson", ndjsonDecoder{}, []string{".ndjson", ".jsonl", ".json"}},
+	{"csv", csvDecoder{}, []string{".csv"}},
+}
+
+// registerDecoder adds a decoder for the given file extensions.
+func registerDecoder(name string, decoder Decoder, extensions ...string) {
+	decoders = append(decoders, registeredDecoder{name, decoder, extensions})
+}
+
+// decoderFor picks the decoder for a file.
+//
+// If only one decoder handles the file's extension it is used, otherwise the
+// contents are sniffed by each decoder that could handle it.
+func decoderFor(filename string, contents []byte) (string, Decoder, error) {
+	ext := strings.ToLower(path.Ext(filename))
+
+	var candidates []registeredDecoder
+	for _, d := range decoders {
+		for _, e := range d.extensions {
+			if e == ext {
+				candidates = append(candidates, d)
+				break
+			}
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0].name, candidates[0].decoder, nil
+	} else if len(candidates) == 0 {
+		candidates = decoders
+	}
+
+	for _, d := range candidates {
+		if d.decoder.Sniff(contents) {
+			return d.name, d.decoder, nil
+		}
+	}
+	return "", nil, fmt.Errorf("No decoder recognizes the format of %s", filename)
+}
+
+// decodeFile parses the contents of a dump file with the decoder for its format,
+// returning the name of the format along with the dataset.
+func decodeFile(filename string, timestamp time.Time, contents []byte) (string, dataset, error) {
+	name, decoder, err := decoderFor(filename, contents)
+	if err != nil {
+		return "", dataset{}, err
+	}
+	data, err := decoder.Decode(timestamp, contents)
+	return name, data, err
+}
+
+// firstByte finds the first non-whitespace byte of the contents.
+func firstByte(contents []byte) byte {
+	trimmed := bytes.TrimSpace(contents)
+	if len(trimmed) == 0 {
+		return 0
+	}
+	return trimmed[0]
+}
+
+// firstLine finds the first non-blank line of the contents.
+func firstLine(contents []byte) []byte {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	scanner.Buffer(nil, len(contents)+1)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			return line
+		}
+	}
+	return nil
+}
+
+// groupRecord is a dumpFormat that carries its own 'group_id' field, rather than
+// being keyed by it.
+type groupRecord struct {
+	dumpFormat
+}
+
+// UnmarshalJSON implements JSON's Unmarshaler interface, pulling the 'group_id'
+// with the same tolerance for string-encoded numbers as the other fields.
+func (r *groupRecord) UnmarshalJSON(data []byte) error {
+	r.dumpFormat.UnmarshalJSON(data)
+	defer r.keepRaw(string(data))
+
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		// already noted as an invalid record
+		return nil
+	}
+
+	var err error
+	if r.GroupID, err = intField(raw, "group_id"); err != nil {
+		r.invalidate("group_id", err)
+	}
+	return nil
+}
+
+// objectDecoder handles CUIT's format of a JSON object keyed by group ID.
+type objectDecoder struct{}
+
+// Sniff implements Decoder.
+func (objectDecoder) Sniff(contents []byte) bool {
+	return firstByte(contents) == '{' && !(ndjsonDecoder{}).Sniff(contents)
+}
+
+// Decode implements Decoder.
+func (objectDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	return parseData(timestamp, contents)
+}
+
+// Stream implements Streamer, reading one group at a time from the object rather
+// than unmarshaling all of it. A declared format version must come before the groups.
+func (objectDecoder) Stream(timestamp time.Time, r io.Reader, emit func(dumpFormat) error) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+
+	var (
+		version  = currentVersion
+		declared bool
+	)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("Error parsing bytes => %s", err.Error())
+		}
+		id, _ := token.(string)
+
+		var raw json.RawMessage
+		if err = decoder.Decode(&raw); err != nil {
+			return fmt.Errorf("Error parsing group %s => %s", id, err.Error())
+		}
+
+		if id == versionKey {
+			header := map[string]json.RawMessage{versionKey: raw}
+			if version, declared, err = declaredVersion(header); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = emit(parseGroup(timestamp, id, raw, version, declared)); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// arrayDecoder handles a JSON array of objects, each with its own 'group_id'.
+type arrayDecoder struct{}
+
+// Sniff implements Decoder.
+func (arrayDecoder) Sniff(contents []byte) bool {
+	return firstByte(contents) == '['
+}
+
+// Decode implements Decoder.
+func (d arrayDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	return collect(d, timestamp, contents)
+}
+
+// Stream implements Streamer.
+func (arrayDecoder) Stream(timestamp time.Time, r io.Reader, emit func(dumpFormat) error) error {
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '['); err != nil {
+		return err
+	}
+	for i := 1; decoder.More(); i++ {
+		var r groupRecord
+		if err := decoder.Decode(&r); err != nil {
+			return fmt.Errorf("Error parsing record %d => %s", i, err.Error())
+		}
+		r.annotate(timestamp)
+		if err := emit(r.dumpFormat); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, ']')
+}
+
+// ndjsonDecoder handles newline delimited JSON objects, each with its own 'group_id'.
+type ndjsonDecoder struct{}
+
+// Sniff implements Decoder.
+// The first line must be a whole JSON object with a 'group_id'.
+func (ndjsonDecoder) Sniff(contents []byte) bool {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(firstLine(contents), &raw); err != nil {
+		return false
+	}
+	_, exists := raw["group_id"]
+	return exists
+}
+
+// Decode implements Decoder.
+func (d ndjsonDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	return collect(d, timestamp, contents)
+}
+
+// Stream implements Streamer.
+func (ndjsonDecoder) Stream(timestamp time.Time, r io.Reader, emit func(dumpFormat) error) error {
+	decoder := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var r groupRecord
+		if err := decoder.Decode(&r); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Error parsing record %d => %s", i, err.Error())
+		}
+		r.annotate(timestamp)
+		if err := emit(r.dumpFormat); err != nil {
+			return err
+		}
+	}
+}
+
+// csvDecoder handles CSV with a header row naming the 'group_id', 'name',
+// 'parent_id' and 'client_count' columns, in any order.
+type csvDecoder struct{}
+
+// csvColumns are the columns required in the header row.
+var csvColumns = []string{"group_id", "name", "parent_id", "client_count"}
+
+// Sniff implements Decoder.
+func (csvDecoder) Sniff(contents []byte) bool {
+	header := string(firstLine(contents))
+	return strings.Contains(header, ",") && strings.Contains(header, "group_id")
+}
+
+// Decode implements Decoder.
+func (d csvDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	return collect(d, timestamp, contents)
+}
+
+// Stream implements Streamer.
+func (csvDecoder) Stream(timestamp time.Time, r io.Reader, emit func(dumpFormat) error) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("Failed to read CSV header => %s", err.Error())
+	}
+	index := make(map[string]int)
+	for i, column := range header {
+		index[strings.TrimSpace(column)] = i
+	}
+	for _, column := range csvColumns {
+		if _, exists := index[column]; !exists {
+			return fmt.Errorf("CSV header missing column '%s'", column)
+		}
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Failed to read CSV => %s", err.Error())
+		}
+
+		var d dumpFormat
+		d.GroupName = row[index["name"]]
+		for _, field := range []struct {
+			column string
+			value  *int
+		}{
+			{"group_id", &d.GroupID},
+			{"parent_id", &d.ParentID},
+			{"client_count", &d.ClientCount},
+		} {
+			value := strings.TrimSpace(row[index[field.column]])
+			if *field.value, err = strconv.Atoi(value); err != nil {
+				d.invalidate(field.column, fmt.Errorf("Failed to convert '%s', %s, to int on line %d => {%s}",
+					field.column, value, line, err))
+			}
+		}
+		d.keepRaw(strings.Join(row, ","))
+
+		d.annotate(timestamp)
+		if err = emit(d); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testingArray = `[
+  {"group_id": 152, "name": "Lerner 3", "client_count": 70, "parent_id": 84},
+  {"group_id": "131", "name": "Butler Library 3", "client_count": "328", "parent_id": "103"},
+  {"group_id": 155, "name": "JJ's Place", "client_count": 90, "parent_id": 75},
+  {"group_id": 130, "name": "Butler Library 2", "client_count": 412, "parent_id": 103}
+]`
+
+var testingNDJSON = `{"group_id": 152, "name": "Lerner 3", "client_count": 70, "parent_id": 84}
+{"group_id": "131", "name": "Butler Library 3", "client_count": "328", "parent_id": "103"}
+{"group_id": 155, "name": "JJ's Place", "client_count": 90, "parent_id": 75}
+
+{"group_id": 130, "name": "Butler Library 2", "client_count": 412, "parent_id": 103}
+`
+
+var testingCSV = `group_id,name,client_count,parent_id
+152,Lerner 3,70,84
+131,Butler Library 3,328,103
+155,JJ's Place,90,75
+130,"Butler Library 2",412,103
+`
+
+var decoderTests = []struct {
+	filename string
+	contents string
+	decoder  string
+}{
+	{"2014-10-31-15-00.json", testingData1, "cuit"},
+	{"2014-10-31-15-00.json", testingData2, "cuit"},
+	{"2014-10-31-15-00.json", testingArray, "array"},
+	{"2014-10-31-15-00.json", testingNDJSON, "ndjson"},
+	{"2014-10-31-15-00.ndjson", testingNDJSON, "ndjson"},
+	{"2014-10-31-15-00.csv", testingCSV, "csv"},
+	{"2014-10-31-15-00.txt", testingCSV, "csv"},
+	{"2014-10-31-15-00.txt", testingArray, "array"},
+}
+
+// TestDecoders checks that every format is detected and decodes to the same dataset.
+func TestDecoders(t *testing.T) {
+	for _, tt := range decoderTests {
+		name, decoder, err := decoderFor(tt.filename, []byte(tt.contents))
+		if err != nil {
+			t.Errorf("Failed to find decoder for %s => %s", tt.decoder, err)
+			continue
+		}
+		if name != tt.decoder {
+			t.Errorf("Expected the %s decoder for %s, found %s", tt.decoder, tt.filename, name)
+			continue
+		}
+
+		data, err := decoder.Decode(time.Time{}, []byte(tt.contents))
+		if err != nil {
+			t.Errorf("Failed to decode with %s => %s", name, err)
+			continue
+		}
+		if len(data) != len(expectedData) {
+			t.Errorf("Expected %d records from %s, found %d", len(expectedData), name, len(data))
+		}
+		for _, d := range data {
+			// the version depends on the encoding, which varies by test
+			d.Version = 0
+			found := false
+			for _, e := range expectedData {
+				if reflect.DeepEqual(d, e) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("No match in expected data for %#v from %s", d, name)
+			}
+		}
+	}
+}
+
+// TestDecoderUnknownFormat checks that unrecognized contents are rejected.
+func TestDecoderUnknownFormat(t *testing.T) {
+	if _, _, err := decoderFor("2014-10-31-15-00.txt", []byte("hello")); err == nil {
+		t.Error("Expected no decoder for unknown format")
+	}
+}
+
+// TestCSVMissingColumn checks that the CSV header must name every column.
+func TestCSVMissingColumn(t *testing.T) {
+	if _, err := (csvDecoder{}).Decode(time.Time{}, []byte("group_id,name\n1,a\n")); err == nil {
+		t.Error("Expected error for missing columns")
+	}
+}
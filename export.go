@@ -0,0 +1,660 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["export"] = command{
+		description: "write density data or a rollup as CSV, NDJSON, CUIT dumps or monthly Parquet files, -from and -to limit the range",
+		run:         exportCommand,
+	}
+}
+
+// export formats, set by the `-format` flag of the export command
+const (
+	exportCSV     = "csv"
+	exportNDJSON  = "ndjson"
+	exportCUIT    = "cuit"
+	exportParquet = "parquet"
+)
+
+// exportTables are the tables and views that can be exported, along with the column
+// each is ordered and limited by.
+var exportTables = map[string]string{
+	"density_data":           "dump_time",
+	"filled_data":            "dump_time",
+	"hourly_data":            "hour",
+	"hour_window":            "hour",
+	"day_window":             "day",
+	"week_window":            "week",
+	"month_window":           "month",
+	"filled_hour_window":     "hour",
+	"breakdown_hour_window":  "hour",
+	"breakdown_day_window":   "day",
+	"breakdown_week_window":  "week",
+	"breakdown_month_window": "month",
+}
+
+// exportFilter limits the rows exported. Zero times, GroupID or an empty Building
+// don't limit anything. Building is either a parent ID or name.
+type exportFilter struct {
+	Table      string
+	Start, End time.Time
+	GroupID    int
+	Building   string
+}
+
+// query selects the rows of the filter's table, in order.
+func (f exportFilter) query() (string, []interface{}, error) {
+	column, where, args, err := f.where()
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("SELECT * FROM %s%s ORDER BY %s, group_id", f.Table, where, column), args, nil
+}
+
+// months selects the UTC months with rows in the filter's table, in order.
+func (f exportFilter) months() (string, []interface{}, error) {
+	column, where, args, err := f.where()
+	if err != nil {
+		return "", nil, err
+	}
+	month := fmt.Sprintf("date_trunc('month', %s AT TIME ZONE 'UTC')", column)
+	return fmt.Sprintf("SELECT DISTINCT %s FROM %s%s ORDER BY %s", month, f.Table, where, month), args, nil
+}
+
+// fingerprint selects a summary of the rows in the filter's table, their count
+// along with the count of the dumps behind them and when the last was ingested. It's
+// cheap enough to take for every month, and changes whenever a dump in it is added,
+// reparsed or removed. A view is only as fresh as its last refresh, so a month
+// exported in between a dump and the refresh is written again with the next dump.
+func (f exportFilter) fingerprint() (string, []interface{}, error) {
+	column, where, args, err := f.where()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the dumps rolled up into the table's rows, in the same range
+	dumpTime := "dump_time"
+	if column != "dump_time" {
+		dumpTime = fmt.Sprintf("date_trunc('%s', dump_time)", column)
+	}
+	var conditions []string
+	if !f.Start.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s >= $%d", dumpTime, len(conditions)+1))
+	}
+	if !f.End.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("%s <= $%d", dumpTime, len(conditions)+1))
+	}
+	var dumpsWhere string
+	if len(conditions) > 0 {
+		dumpsWhere = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return fmt.Sprintf("SELECT (SELECT count(*) FROM %s%s) || ':' || "+
+		"(SELECT count(*) || ':' || COALESCE(floor(extract(epoch FROM max(ingested_at)) * 1000000)::bigint, 0) FROM dumps%s)",
+		f.Table, where, dumpsWhere), args, nil
+}
+
+// where builds the WHERE clause of the filter, along with the column the table is
+// ordered and limited by.
+func (f exportFilter) where() (string, string, []interface{}, error) {
+	column, ok := exportTables[f.Table]
+	if !ok {
+		return "", "", nil, fmt.Errorf("Can't export %s", f.Table)
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if !f.Start.IsZero() {
+		where(column+" >= $%d", f.Start)
+	}
+	if !f.End.IsZero() {
+		where(column+" <= $%d", f.End)
+	}
+	if f.GroupID != 0 {
+		where("group_id = $%d", f.GroupID)
+	}
+	if f.Building != "" {
+		if id, err := strconv.Atoi(f.Building); err == nil {
+			where("parent_id = $%d", id)
+		} else {
+			where("parent_name = $%d", f.Building)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return column, "", nil, nil
+	}
+	return column, " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// exporter writes exported rows.
+type exporter interface {
+	// Columns is given the name and database type of every column before any rows.
+	Columns(names, types []string) error
+	// Write is given the values of a row as they're scanned.
+	Write(values []interface{}) error
+	Close() error
+}
+
+// textColumns converts the values of each row for the text exporters.
+type textColumns struct {
+	names   []string
+	numeric []bool
+}
+
+// Columns implements exporter.
+func (c *textColumns) Columns(names, types []string) error {
+	c.names = names
+	c.numeric = make([]bool, len(types))
+	for i, t := range types {
+		c.numeric[i] = t == "NUMERIC"
+	}
+	return nil
+}
+
+// convert the values of a row, see exportValue.
+func (c *textColumns) convert(values []interface{}) []interface{} {
+	converted := make([]interface{}, len(values))
+	for i, v := range values {
+		converted[i] = exportValue(v, c.numeric[i])
+	}
+	return converted
+}
+
+// exportValue converts a scanned value to what's exported, times are in NY time
+// and numerics, which pq scans as bytes, are kept as numbers.
+func exportValue(value interface{}, numeric bool) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.In(NY).Format(time.RFC3339)
+	case []byte:
+		if numeric {
+			return json.Number(v)
+		}
+		return json.RawMessage(v)
+	}
+	return value
+}
+
+// csvExporter writes a header of the columns then a line per row.
+type csvExporter struct {
+	textColumns
+	w      *csv.Writer
+	header bool
+}
+
+// newCSVExporter writes CSV to the writer.
+func newCSVExporter(w io.Writer) *csvExporter {
+	return &csvExporter{w: csv.NewWriter(w)}
+}
+
+// Write implements exporter.
+func (e *csvExporter) Write(values []interface{}) error {
+	if !e.header {
+		if err := e.w.Write(e.names); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	fields := make([]string, len(values))
+	for i, value := range e.convert(values) {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			fields[i] = v
+		case json.Number:
+			fields[i] = string(v)
+		case json.RawMessage:
+			fields[i] = string(v)
+		case float64:
+			fields[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			fields[i] = fmt.Sprint(v)
+		}
+	}
+	return e.w.Write(fields)
+}
+
+// Close implements exporter.
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonExporter writes each row as a JSON object on its own line.
+type ndjsonExporter struct {
+	textColumns
+	encoder *json.Encoder
+}
+
+// newNDJSONExporter writes NDJSON to the writer.
+func newNDJSONExporter(w io.Writer) *ndjsonExporter {
+	return &ndjsonExporter{encoder: json.NewEncoder(w)}
+}
+
+// Write implements exporter. Any bytes that aren't JSON, unlike the jsonb extras,
+// are written as a string.
+func (e *ndjsonExporter) Write(values []interface{}) error {
+	row := make(map[string]interface{}, len(e.names))
+	for i, value := range e.convert(values) {
+		if raw, ok := value.(json.RawMessage); ok && !json.Valid(raw) {
+			row[e.names[i]] = string(raw)
+		} else {
+			row[e.names[i]] = value
+		}
+	}
+	return e.encoder.Encode(row)
+}
+
+// Close implements exporter.
+func (e *ndjsonExporter) Close() error {
+	return nil
+}
+
+// cuitExporter regenerates the CUIT dumps, a file per dump time named as the
+// processor expects, from rows of `density_data` ordered by dump time.
+//
+// Each group's extras are written back as keys of its record, so a regenerated
+// dump is ingested just as the original was. Access points and breakdowns aren't
+// regenerated, and groups with a null count are left out, as CUIT never sends one.
+type cuitExporter struct {
+	textColumns
+	dir      string
+	dumpTime string
+	groups   map[string]map[string]interface{}
+	files    int
+}
+
+// newCUITExporter writes the dumps to the directory.
+func newCUITExporter(dir string) *cuitExporter {
+	return &cuitExporter{dir: dir}
+}
+
+// Columns implements exporter, checking the columns needed for a dump are there.
+func (e *cuitExporter) Columns(names, types []string) error {
+	for _, column := range []string{"dump_time", "group_id", "group_name", "parent_id", "client_count"} {
+		found := false
+		for _, name := range names {
+			found = found || name == column
+		}
+		if !found {
+			return fmt.Errorf("CUIT dumps need the %s column", column)
+		}
+	}
+	return e.textColumns.Columns(names, types)
+}
+
+// Write implements exporter.
+func (e *cuitExporter) Write(values []interface{}) error {
+	row := make(map[string]interface{}, len(e.names))
+	for i, value := range e.convert(values) {
+		row[e.names[i]] = value
+	}
+
+	dumpTime, _ := row["dump_time"].(string)
+	if dumpTime != e.dumpTime {
+		if err := e.flush(); err != nil {
+			return err
+		}
+		e.dumpTime = dumpTime
+		e.groups = make(map[string]map[string]interface{})
+	}
+
+	if row["client_count"] == nil {
+		log.Printf("WARNING: Left group %v out of the dump at %s, its client count is null", row["group_id"], dumpTime)
+		return nil
+	}
+
+	var record map[string]interface{}
+	if extras, ok := row["extras"].(json.RawMessage); ok {
+		if err := json.Unmarshal(extras, &record); err != nil {
+			return fmt.Errorf("Failed to unpack extras of group %v => %s", row["group_id"], err.Error())
+		}
+	}
+	if record == nil {
+		record = make(map[string]interface{})
+	}
+	record["name"] = row["group_name"]
+	record["parent_id"] = row["parent_id"]
+	record["client_count"] = row["client_count"]
+
+	e.groups[fmt.Sprint(row["group_id"])] = record
+	return nil
+}
+
+// flush writes the dump being built, if there is one.
+func (e *cuitExporter) flush() error {
+	if e.dumpTime == "" {
+		return nil
+	}
+	tm, err := time.Parse(time.RFC3339, e.dumpTime)
+	if err != nil {
+		return fmt.Errorf("Invalid dump time, %s => %s", e.dumpTime, err.Error())
+	}
+
+	contents, err := json.MarshalIndent(e.groups, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode dump at %s => %s", e.dumpTime, err.Error())
+	}
+	filename := path.Join(e.dir, tm.In(NY).Format(datetimeFormat)+".json")
+	if err = ioutil.WriteFile(filename, contents, 0644); err != nil {
+		return fmt.Errorf("Failed to write dump, %s => %s", filename, err.Error())
+	}
+	e.files++
+	return nil
+}
+
+// Close implements exporter, writing the last dump.
+func (e *cuitExporter) Close() error {
+	if err := e.flush(); err != nil {
+		return err
+	}
+	log.Printf("Wrote %d dumps to %s", e.files, e.dir)
+	return nil
+}
+
+// parquetExporter writes the rows to a Parquet file, see parquetWriter.
+type parquetExporter struct {
+	w io.Writer
+	p *parquetWriter
+}
+
+// newParquetExporter writes Parquet to the writer.
+func newParquetExporter(w io.Writer) *parquetExporter {
+	return &parquetExporter{w: w}
+}
+
+// Columns implements exporter, beginning the file.
+func (e *parquetExporter) Columns(names, types []string) (err error) {
+	e.p, err = newParquetWriter(e.w, names, types)
+	return err
+}
+
+// Write implements exporter.
+func (e *parquetExporter) Write(values []interface{}) error {
+	return e.p.Write(values)
+}
+
+// Close implements exporter, writing the footer.
+func (e *parquetExporter) Close() error {
+	if e.p == nil {
+		return nil
+	}
+	return e.p.Close()
+}
+
+// parquetPath is the file a month of the table is exported to, in a directory per
+// month so the months can be read as a partitioned dataset.
+func parquetPath(dir, table string, month time.Time) string {
+	return path.Join(dir, table, "month="+month.Format("2006-01"), table+"-"+month.Format("2006-01")+".parquet")
+}
+
+// parquetFingerprintPath is the file the fingerprint of an exported month is kept
+// in, next to it but hidden so it's not read as part of the dataset.
+func parquetFingerprintPath(filename string) string {
+	return path.Join(path.Dir(filename), "."+path.Base(filename)+".fingerprint")
+}
+
+// parquetExported checks whether the month has been exported with the fingerprint,
+// so its rows haven't changed since and it needn't be written again.
+func parquetExported(filename, fingerprint string) bool {
+	if _, err := os.Stat(filename); err != nil {
+		return false
+	}
+	exported, err := ioutil.ReadFile(parquetFingerprintPath(filename))
+	return err == nil && string(exported) == fingerprint
+}
+
+// exportFingerprint queries the fingerprint of the rows in the filter's table, see
+// exportFilter.fingerprint.
+func exportFingerprint(db *sql.DB, filter exportFilter) (string, error) {
+	query, args, err := filter.fingerprint()
+	if err != nil {
+		return "", err
+	}
+	var fingerprint string
+	if err = db.QueryRow(query, args...).Scan(&fingerprint); err != nil {
+		return "", fmt.Errorf("Failed to fingerprint %s => %s", filter.Table, err.Error())
+	}
+	return fingerprint, nil
+}
+
+// exportMonths are the UTC months with rows in the filter's table.
+func exportMonths(db *sql.DB, filter exportFilter) ([]time.Time, error) {
+	query, args, err := filter.months()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query months of %s => %s", filter.Table, err.Error())
+	}
+	defer rows.Close()
+
+	var months []time.Time
+	for rows.Next() {
+		var month time.Time
+		if err = rows.Scan(&month); err != nil {
+			return nil, fmt.Errorf("Failed to scan months of %s => %s", filter.Table, err.Error())
+		}
+		months = append(months, partitionMonth(month))
+	}
+	return months, rows.Err()
+}
+
+// exportParquetMonths writes a Parquet file for every month of the filter's table in the
+// range. Months whose rows haven't changed since they were exported are skipped
+// unless rewrite is set, so running it again only writes the new and changed months.
+func exportParquetMonths(db *sql.DB, filter exportFilter, dir string, rewrite bool) error {
+	months, err := exportMonths(db, filter)
+	if err != nil {
+		return err
+	}
+
+	var written, skipped int
+	for _, month := range months {
+		// the whole month is written, whatever the range, and it's fingerprinted
+		// before it's exported so rows added in between are only ever written again
+		monthFilter := exportFilter{Table: filter.Table, Start: month, End: month.AddDate(0, 1, 0).Add(-time.Microsecond)}
+		fingerprint, err := exportFingerprint(db, monthFilter)
+		if err != nil {
+			return err
+		}
+		filename := parquetPath(dir, filter.Table, month)
+		if !rewrite && parquetExported(filename, fingerprint) {
+			skipped++
+			continue
+		}
+		if err = os.MkdirAll(path.Dir(filename), 0755); err != nil {
+			return fmt.Errorf("Failed to create directory, %s => %s", path.Dir(filename), err.Error())
+		}
+
+		// move the file into place once it's written so an interrupted export never
+		// leaves a partial file behind, then record what it was exported from
+		tmp := filename + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			return fmt.Errorf("Failed to create file, %s => %s", tmp, err.Error())
+		}
+		e := newParquetExporter(f)
+		count, err := exportRows(db, monthFilter, e)
+		if err == nil {
+			err = e.Close()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp, filename)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("Failed to export %s => %s", filename, err.Error())
+		}
+		if err = ioutil.WriteFile(parquetFingerprintPath(filename), []byte(fingerprint), 0644); err != nil {
+			return fmt.Errorf("Failed to write the fingerprint of %s => %s", filename, err.Error())
+		}
+		log.Printf("Exported %d rows to %s", count, filename)
+		written++
+	}
+	log.Printf("Exported %d months of %s, skipped %d already exported", written, filter.Table, skipped)
+	return nil
+}
+
+// exportRows writes every row of the filter's table to the exporter.
+func exportRows(db *sql.DB, filter exportFilter, e exporter) (int, error) {
+	query, args, err := filter.query()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to query %s => %s", filter.Table, err.Error())
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read columns of %s => %s", filter.Table, err.Error())
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read columns of %s => %s", filter.Table, err.Error())
+	}
+	typeNames := make([]string, len(types))
+	for i, t := range types {
+		typeNames[i] = t.DatabaseTypeName()
+	}
+	if err = e.Columns(columns, typeNames); err != nil {
+		return 0, err
+	}
+
+	var (
+		count  int
+		values = make([]interface{}, len(columns))
+		ptrs   = make([]interface{}, len(columns))
+	)
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(ptrs...); err != nil {
+			return count, fmt.Errorf("Failed to scan %s => %s", filter.Table, err.Error())
+		}
+		if err = e.Write(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		return count, fmt.Errorf("Failed to read %s => %s", filter.Table, err.Error())
+	}
+	return count, nil
+}
+
+// exportCommand writes the density data, or a rollup, in the range to a file or a
+// directory of dumps.
+func exportCommand(args []string) error {
+	var (
+		flags    = newFlagSet("export")
+		period   = rangeFlags(flags)
+		table    = flags.String("table", "density_data", "table or rollup view to export")
+		format   = flags.String("format", exportCSV, "csv, ndjson, cuit, which regenerates the dump files of density_data, or parquet")
+		group    = flags.Int("group", 0, "only export the group with this ID")
+		building = flags.String("building", "", "only export the groups of the building with this parent ID or name")
+		out      = flags.String("out", "", "file to write, or directory for cuit dumps and parquet months (default: stdout, or the current directory)")
+		rewrite  = flags.Bool("rewrite", false, "rewrite parquet months even if they haven't changed since they were exported")
+	)
+	flags.Parse(args)
+
+	var (
+		filter = exportFilter{Table: *table, GroupID: *group, Building: *building}
+		err    error
+	)
+	if *period.from != "" || *period.to != "" {
+		if filter.Start, filter.End, err = period.parse(); err != nil {
+			return err
+		}
+	}
+
+	var e exporter
+	switch *format {
+	case exportParquet:
+		// each file holds a whole month, so they can't be limited to some groups
+		if filter.GroupID != 0 || filter.Building != "" {
+			return fmt.Errorf("Parquet exports can't be limited by -group or -building")
+		}
+		dir := *out
+		if dir == "" {
+			dir = "."
+		}
+		db := dbConnect()
+		defer db.Close()
+		return exportParquetMonths(db, filter, dir, *rewrite)
+
+	case exportCUIT:
+		if filter.Table != "density_data" {
+			return fmt.Errorf("Only density_data can be exported as CUIT dumps")
+		}
+		dir := *out
+		if dir == "" {
+			dir = "."
+		}
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("Failed to create directory, %s => %s", dir, err.Error())
+		}
+		e = newCUITExporter(dir)
+
+	case exportCSV, exportNDJSON:
+		w := os.Stdout
+		if *out != "" {
+			if w, err = os.Create(*out); err != nil {
+				return fmt.Errorf("Failed to create file, %s => %s", *out, err.Error())
+			}
+			defer w.Close()
+		}
+		if *format == exportCSV {
+			e = newCSVExporter(w)
+		} else {
+			e = newNDJSONExporter(w)
+		}
+
+	default:
+		return fmt.Errorf("Unknown export format, %s", *format)
+	}
+
+	db := dbConnect()
+	defer db.Close()
+
+	count, err := exportRows(db, filter, e)
+	if err != nil {
+		return err
+	}
+	if err = e.Close(); err != nil {
+		return err
+	}
+	log.Printf("Exported %d rows of %s", count, filter.Table)
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var exportQueryTests = []struct {
+	filter exportFilter
+	query  string
+	args   []interface{}
+}{
+	{
+		exportFilter{Table: "density_data"},
+		"SELECT * FROM density_data ORDER BY dump_time, group_id",
+		nil,
+	},
+	{
+		exportFilter{Table: "day_window", Start: time.Date(2014, 10, 1, 0, 0, 0, 0, tz), GroupID: 152},
+		"SELECT * FROM day_window WHERE day >= $1 AND group_id = $2 ORDER BY day, group_id",
+		[]interface{}{time.Date(2014, 10, 1, 0, 0, 0, 0, tz), 152},
+	},
+	{
+		exportFilter{Table: "hour_window", Building: "84"},
+		"SELECT * FROM hour_window WHERE parent_id = $1 ORDER BY hour, group_id",
+		[]interface{}{84},
+	},
+	{
+		exportFilter{Table: "density_data", Building: "Butler"},
+		"SELECT * FROM density_data WHERE parent_name = $1 ORDER BY dump_time, group_id",
+		[]interface{}{"Butler"},
+	},
+	{
+		exportFilter{Table: "breakdown_week_window", Building: "84"},
+		"SELECT * FROM breakdown_week_window WHERE parent_id = $1 ORDER BY week, group_id",
+		[]interface{}{84},
+	},
+}
+
+// TestExportQuery checks the query built for each filter.
+func TestExportQuery(t *testing.T) {
+	for _, tt := range exportQueryTests {
+		query, args, err := tt.filter.query()
+		if err != nil {
+			t.Errorf("Failed to build query for %+v => %s", tt.filter, err)
+			continue
+		}
+		if query != tt.query || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("Expected %s %v, found %s %v", tt.query, tt.args, query, args)
+		}
+	}
+
+	if _, _, err := (exportFilter{Table: "dumps; DROP TABLE dumps"}).query(); err == nil {
+		t.Error("Expected only the listed tables to be exported")
+	}
+}
+
+var (
+	exportColumns = []string{"dump_time", "group_id", "group_name", "parent_id", "client_count", "extras"}
+	exportTypes   = []string{"TIMESTAMPTZ", "INT4", "TEXT", "INT4", "INT4", "JSONB"}
+	exportRowsIn  = [][]interface{}{
+		{time.Date(2014, 10, 31, 15, 0, 0, 0, time.UTC), int64(152), "Lerner 3", int64(84), int64(24), []byte(`{"firmware": "8.2"}`)},
+		{time.Date(2014, 10, 31, 15, 0, 0, 0, time.UTC), int64(131), "Butler Library 3", int64(103), nil, nil},
+		{time.Date(2014, 10, 31, 15, 15, 0, 0, time.UTC), int64(152), "Lerner 3", int64(84), int64(30), nil},
+	}
+)
+
+// writeExport passes the test rows to the exporter.
+func writeExport(t *testing.T, e exporter) {
+	if err := e.Columns(exportColumns, exportTypes); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range exportRowsIn {
+		if err := e.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExportCSV checks that a header is written before the rows.
+func TestExportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	writeExport(t, newCSVExporter(&buf))
+
+	expected := `dump_time,group_id,group_name,parent_id,client_count,extras
+2014-10-31T11:00:00-04:00,152,Lerner 3,84,24,"{""firmware"": ""8.2""}"
+2014-10-31T11:00:00-04:00,131,Butler Library 3,103,,
+2014-10-31T11:15:00-04:00,152,Lerner 3,84,30,
+`
+	if buf.String() != expected {
+		t.Errorf("Expected CSV\n%s\nfound\n%s", expected, buf.String())
+	}
+}
+
+// TestExportNDJSON checks that each row is an object with the extras kept as JSON.
+func TestExportNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	writeExport(t, newNDJSONExporter(&buf))
+
+	decoder := json.NewDecoder(&buf)
+	var first map[string]interface{}
+	if err := decoder.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if extras, ok := first["extras"].(map[string]interface{}); !ok || extras["firmware"] != "8.2" {
+		t.Errorf("Expected the extras as an object, found %v", first["extras"])
+	}
+	if first["dump_time"] != "2014-10-31T11:00:00-04:00" {
+		t.Errorf("Expected the dump time in NY time, found %v", first["dump_time"])
+	}
+}
+
+// TestExportCUIT checks that the regenerated dumps can be ingested again.
+func TestExportCUIT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeExport(t, newCUITExporter(dir))
+
+	filename := path.Join(dir, "2014-10-31-11-00.json")
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Expected a dump named for its time => %s", err)
+	}
+	if _, err = os.Stat(path.Join(dir, "2014-10-31-11-15.json")); err != nil {
+		t.Errorf("Expected a dump for each dump time => %s", err)
+	}
+
+	tm, err := getDate(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parseData(tm, contents)
+	if err != nil {
+		t.Fatalf("Failed to parse the regenerated dump => %s", err)
+	}
+	if len(data) != 1 {
+		t.Fatalf("Expected 1 group in the dump, the null count left out, found %d", len(data))
+	}
+	if d := data[0]; d.GroupID != 152 || d.ClientCount != 24 || d.GroupName != "Lerner 3" || d.Extras["firmware"] != "8.2" {
+		t.Errorf("Expected group 152 as it was exported, found %+v", d)
+	}
+}
+
+// TestParquetExported checks that a month is only skipped once it's been exported
+// with the same fingerprint.
+func TestParquetExported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	month := time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)
+	filename := parquetPath(dir, "density_data", month)
+	if expected := path.Join(dir, "density_data", "month=2014-10", "density_data-2014-10.parquet"); filename != expected {
+		t.Errorf("Expected %s, found %s", expected, filename)
+	}
+	if expected := path.Join(dir, "density_data", "month=2014-10", ".density_data-2014-10.parquet.fingerprint"); parquetFingerprintPath(filename) != expected {
+		t.Errorf("Expected %s, found %s", expected, parquetFingerprintPath(filename))
+	}
+
+	exported := path.Join(dir, "exported.parquet")
+	if err = ioutil.WriteFile(parquetFingerprintPath(exported), []byte("96:1234"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if parquetExported(exported, "96:1234") {
+		t.Error("Expected a month without its file not to be exported")
+	}
+	if err = ioutil.WriteFile(exported, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !parquetExported(exported, "96:1234") {
+		t.Error("Expected a month exported with the same fingerprint to be skipped")
+	}
+	if parquetExported(exported, "97:5678") {
+		t.Error("Expected a month whose rows changed to be written again")
+	}
+
+	unfingerprinted := path.Join(dir, "unfingerprinted.parquet")
+	if err = ioutil.WriteFile(unfingerprinted, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if parquetExported(unfingerprinted, "96:1234") {
+		t.Error("Expected a month without a fingerprint to be written again")
+	}
+}
+
+// TestExportFingerprint checks the query for the fingerprint of a month, with the
+// dumps limited by the table's period.
+func TestExportFingerprint(t *testing.T) {
+	start := time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2014, 11, 1, 0, 0, 0, 0, time.UTC)
+	for table, expected := range map[string]string{
+		"density_data": "SELECT (SELECT count(*) FROM density_data WHERE dump_time >= $1 AND dump_time <= $2) || ':' || " +
+			"(SELECT count(*) || ':' || COALESCE(floor(extract(epoch FROM max(ingested_at)) * 1000000)::bigint, 0) FROM dumps " +
+			"WHERE dump_time >= $1 AND dump_time <= $2)",
+		"week_window": "SELECT (SELECT count(*) FROM week_window WHERE week >= $1 AND week <= $2) || ':' || " +
+			"(SELECT count(*) || ':' || COALESCE(floor(extract(epoch FROM max(ingested_at)) * 1000000)::bigint, 0) FROM dumps " +
+			"WHERE date_trunc('week', dump_time) >= $1 AND date_trunc('week', dump_time) <= $2)",
+	} {
+		query, args, err := exportFilter{Table: table, Start: start, End: end}.fingerprint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query != expected || !reflect.DeepEqual(args, []interface{}{start, end}) {
+			t.Errorf("Expected %s [%s %s], found %s %v", expected, start, end, query, args)
+		}
+	}
+}
+
+// TestExportMonths checks the query for the months of a table.
+func TestExportMonths(t *testing.T) {
+	query, args, err := exportFilter{Table: "hourly_data", GroupID: 152}.months()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "SELECT DISTINCT date_trunc('month', hour AT TIME ZONE 'UTC') FROM hourly_data WHERE group_id = $1 ORDER BY date_trunc('month', hour AT TIME ZONE 'UTC')"
+	if query != expected || !reflect.DeepEqual(args, []interface{}{152}) {
+		t.Errorf("Expected %s [152], found %s %v", expected, query, args)
+	}
+}
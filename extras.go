@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+func init() {
+	commands["extras"] = command{
+		description: "list every unrecognized key seen in the dumps and when",
+		run:         extrasCommand,
+	}
+}
+
+// extrasField collects every key of a group's generic map that isn't one of the
+// fields of its format version.
+func extrasField(raw map[string]interface{}, fields map[string]bool) map[string]interface{} {
+	var extras map[string]interface{}
+	for key, value := range raw {
+		if fields[key] {
+			continue
+		}
+		if extras == nil {
+			extras = make(map[string]interface{})
+		}
+		extras[key] = value
+	}
+	return extras
+}
+
+// extrasJSON encodes the extras for the `extras` column, nil if there are none.
+func (df dumpFormat) extrasJSON() (interface{}, error) {
+	if len(df.Extras) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(df.Extras)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode extras of group %d => {%s}", df.GroupID, err)
+	}
+	return string(encoded), nil
+}
+
+// extraKeys lists every unrecognized key found in the dataset.
+func (data dataset) extraKeys() []string {
+	seen := make(map[string]bool)
+	for _, d := range data {
+		for key := range d.Extras {
+			seen[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(seen))
+	for key := range seen {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// recordExtraKeys notes when each unrecognized key in a dump was seen in the
+// `extra_keys` table, warning about keys that have never been seen before.
+func recordExtraKeys(db *sql.DB, dumpTime time.Time, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	for _, key := range keys {
+		result, err := txn.Exec(`UPDATE extra_keys
+			SET first_seen = LEAST(first_seen, $2), last_seen = GREATEST(last_seen, $2)
+			WHERE key = $1`, key, dumpTime)
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to update extra key, %s => {%s}", key, err)
+		}
+		if updated, err := result.RowsAffected(); err != nil || updated > 0 {
+			continue
+		}
+
+		log.Printf("WARNING: new key, %s, found in dump at %s", key, dumpTime)
+		_, err = txn.Exec(`INSERT INTO extra_keys (key, first_seen, last_seen)
+			VALUES ($1, $2, $2)`, key, dumpTime)
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to insert extra key, %s => {%s}", key, err)
+		}
+	}
+
+	return txn.Commit()
+}
+
+// extrasCommand prints every unrecognized key along with when it was first and
+// last seen.
+func extrasCommand(args []string) error {
+	newFlagSet("extras").Parse(args)
+
+	db := dbConnect()
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT key, first_seen, last_seen, first_ingested
+		FROM extra_keys
+		ORDER BY first_seen, key`)
+	if err != nil {
+		return fmt.Errorf("Failed to query extra keys => {%s}", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			key                        string
+			first, last, firstIngested time.Time
+		)
+		if err = rows.Scan(&key, &first, &last, &firstIngested); err != nil {
+			return fmt.Errorf("Failed to scan extra key => {%s}", err)
+		}
+		fmt.Printf("%s\tfirst seen %s\tlast seen %s\tingested %s\n", key,
+			first.In(NY).Format(datetimeFormat),
+			last.In(NY).Format(datetimeFormat),
+			firstIngested.In(NY).Format(time.RFC3339))
+	}
+	return rows.Err()
+}
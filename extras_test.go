@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// group w/ keys we don't know about
+var dataExtras = `{
+    "name" : "Lerner 3",
+    "client_count" : 70,
+    "parent_id" : 84,
+    "ap_model" : "AP-135",
+    "controller" : {"id" : 4, "up" : true}
+}`
+
+// TestUnmarshalExtras checks that unrecognized keys are kept.
+func TestUnmarshalExtras(t *testing.T) {
+	var d dumpFormat
+	if err := json.Unmarshal([]byte(dataExtras), &d); err != nil {
+		t.Fatalf("Failed to unmarshal data with extras => {%s}", err)
+	}
+
+	expected := map[string]interface{}{
+		"ap_model":   "AP-135",
+		"controller": map[string]interface{}{"id": float64(4), "up": true},
+	}
+	if !reflect.DeepEqual(d.Extras, expected) {
+		t.Errorf("Expected %#v, found %#v", expected, d.Extras)
+	}
+
+	encoded, err := d.extrasJSON()
+	if err != nil || encoded != `{"ap_model":"AP-135","controller":{"id":4,"up":true}}` {
+		t.Errorf("Failed to encode extras, found %v => %v", encoded, err)
+	}
+
+	keys := dataset{d, {}}.extraKeys()
+	if !reflect.DeepEqual(keys, []string{"ap_model", "controller"}) {
+		t.Errorf("Expected both extra keys, found %v", keys)
+	}
+}
+
+// TestNoExtras checks that groups without unrecognized keys have no extras.
+func TestNoExtras(t *testing.T) {
+	var d dumpFormat
+	if err := json.Unmarshal([]byte(dataBreakdown), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Extras != nil {
+		t.Errorf("Expected no extras, found %#v", d.Extras)
+	}
+	if encoded, _ := d.extrasJSON(); encoded != nil {
+		t.Errorf("Expected NULL extras, found %v", encoded)
+	}
+}
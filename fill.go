@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// methods of filling gaps, stored in the `method` column
+const (
+	fillNone   = "none"
+	fillLinear = "linear"
+	fillCarry  = "carry"
+)
+
+var (
+	// fillMethod is how the counts in a gap are synthesized.
+	fillMethod = fillNone
+	// maxFill is the most missing dumps in a row that will be filled.
+	maxFill = 4
+)
+
+// filledPoint is a count synthesized for a group at a missing dump time.
+type filledPoint struct {
+	dumpFormat
+	Method string
+}
+
+// validFillMethod checks that the method is one of the known methods.
+func validFillMethod(method string) bool {
+	switch method {
+	case fillNone, fillLinear, fillCarry:
+		return true
+	}
+	return false
+}
+
+// fillGap synthesizes counts for every group at each missing dump time in the gap.
+//
+// `before` and `after` are the datasets of the dumps on either side of the gap.
+// Linear interpolation only fills groups present on both sides, carrying forward
+// fills every group present before the gap. Gaps longer than `maxFill` are left
+// empty.
+func fillGap(g gap, before, after dataset, method string, every time.Duration) []filledPoint {
+	if method == fillNone || g.Missing > maxFill {
+		return nil
+	}
+
+	afterCounts := make(map[int]int, len(after))
+	for _, d := range after {
+		if !d.Excluded && !d.NullCount {
+			afterCounts[d.GroupID] = d.ClientCount
+		}
+	}
+
+	var points []filledPoint
+	for _, d := range before {
+		if d.Excluded || d.NullCount {
+			continue
+		}
+		next, exists := afterCounts[d.GroupID]
+		if method == fillLinear && !exists {
+			continue
+		}
+
+		for i, tm := range g.times(every) {
+			p := filledPoint{dumpFormat: d, Method: method}
+			p.DumpTime = tm
+			p.EstimatedOccupancy = sql.NullFloat64{}
+			p.PercentFull = sql.NullFloat64{}
+			if method == fillLinear {
+				step := float64(next-d.ClientCount) / float64(g.Missing+1)
+				p.ClientCount = d.ClientCount + int(math.Floor(step*float64(i+1)+0.5))
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// fillGaps replaces the synthesized counts around a newly recorded dump with counts
+// for the given gaps.
+func fillGaps(db *sql.DB, dumpTime time.Time, gaps []gap) error {
+	if fillMethod == fillNone {
+		return nil
+	}
+
+	// a resent dump replaces whatever was synthesized for it
+	if _, err := db.Exec("DELETE FROM interpolated_data WHERE dump_time = $1", dumpTime); err != nil {
+		return fmt.Errorf("Failed to remove interpolated data => {%s}", err)
+	}
+
+	for _, g := range gaps {
+		_, err := db.Exec(`DELETE FROM interpolated_data
+			WHERE dump_time >= $1 AND dump_time <= $2`, g.Start, g.End)
+		if err != nil {
+			return fmt.Errorf("Failed to remove interpolated data => {%s}", err)
+		}
+
+		before, err := loadDataset(db, g.Start.Add(-cadence))
+		if err != nil {
+			return err
+		}
+		after, err := loadDataset(db, g.End.Add(cadence))
+		if err != nil {
+			return err
+		}
+
+		if err = insertFilled(db, fillGap(g, before, after, fillMethod, cadence)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertFilled adds the synthesized counts to the `interpolated_data` table.
+func insertFilled(db *sql.DB, points []filledPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	// estimate the occupancy of the synthesized counts as if they were real
+	data := make(dataset, len(points))
+	first, last := points[0].DumpTime, points[0].DumpTime
+	for i, p := range points {
+		data[i] = p.dumpFormat
+		if p.DumpTime.Before(first) {
+			first = p.DumpTime
+		}
+		if p.DumpTime.After(last) {
+			last = p.DumpTime
+		}
+	}
+	data.estimateOccupancy()
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn(
+		"interpolated_data",
+		"dump_time",
+		"group_id",
+		"group_name",
+		"parent_id",
+		"parent_name",
+		"client_count",
+		"floor",
+		"wing",
+		"zone",
+		"estimated_occupancy",
+		"percent_full",
+		"method",
+	))
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Error prepping PG txn => %s", err.Error())
+	}
+	defer stmt.Close()
+
+	for i, d := range data {
+		_, err = stmt.Exec(
+			d.DumpTime,
+			d.GroupID,
+			d.GroupName,
+			d.ParentID,
+			d.ParentName,
+			d.ClientCount,
+			d.Floor,
+			d.Wing,
+			d.Zone,
+			d.EstimatedOccupancy,
+			d.PercentFull,
+			points[i].Method,
+		)
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to add to bulk insert => %s", err.Error())
+		}
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to execute bulk insert => %s", err.Error())
+	}
+
+	// a gap reaching back past the raw cutoff isn't filled in the downsampled hours
+	_, err = txn.Exec(`DELETE FROM interpolated_data i
+		USING hourly_data h
+		WHERE h.hour = date_trunc('hour', i.dump_time) AND i.dump_time >= $1 AND i.dump_time <= $2`,
+		first, last)
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to remove interpolated data in downsampled hours => %s", err.Error())
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	fillStart = time.Date(2014, time.October, 31, 15, 0, 0, 0, tz)
+	fillGap1  = gap{
+		Source:  "cuit",
+		Start:   fillStart.Add(15 * time.Minute),
+		End:     fillStart.Add(45 * time.Minute),
+		Missing: 3,
+	}
+	fillBefore = dataset{
+		{DumpTime: fillStart, GroupID: 130, GroupName: "Butler Library 2", ClientCount: 100},
+		{DumpTime: fillStart, GroupID: 152, GroupName: "Lerner 3", ClientCount: 40},
+	}
+	fillAfter = dataset{
+		{DumpTime: fillStart.Add(time.Hour), GroupID: 130, GroupName: "Butler Library 2", ClientCount: 200},
+	}
+)
+
+// TestFillLinear checks that counts are interpolated between both sides of a gap.
+func TestFillLinear(t *testing.T) {
+	points := fillGap(fillGap1, fillBefore, fillAfter, fillLinear, cadence)
+	expected := []int{125, 150, 175}
+
+	if len(points) != len(expected) {
+		t.Fatalf("Expected %d points, found %#v", len(expected), points)
+	}
+	for i, p := range points {
+		if p.GroupID != 130 || p.ClientCount != expected[i] || p.Method != fillLinear {
+			t.Errorf("Expected %d for group 130, found %#v", expected[i], p)
+		}
+		if !p.DumpTime.Equal(fillGap1.Start.Add(time.Duration(i) * cadence)) {
+			t.Errorf("Wrong dump time for point %d, found %s", i, p.DumpTime)
+		}
+		if p.GroupName != "Butler Library 2" {
+			t.Errorf("Group details not kept, found %#v", p)
+		}
+	}
+}
+
+// TestFillCarry checks that counts are carried forward for every group.
+func TestFillCarry(t *testing.T) {
+	points := fillGap(fillGap1, fillBefore, fillAfter, fillCarry, cadence)
+	if len(points) != 6 {
+		t.Fatalf("Expected 6 points, found %d", len(points))
+	}
+	for _, p := range points {
+		if (p.GroupID == 130 && p.ClientCount != 100) || (p.GroupID == 152 && p.ClientCount != 40) {
+			t.Errorf("Count not carried forward, found %#v", p)
+		}
+	}
+}
+
+// TestFillMaxGap checks that long gaps are left empty.
+func TestFillMaxGap(t *testing.T) {
+	long := fillGap1
+	long.End = long.Start.Add(time.Duration(maxFill) * cadence)
+	long.Missing = maxFill + 1
+
+	if points := fillGap(long, fillBefore, fillAfter, fillLinear, cadence); len(points) != 0 {
+		t.Errorf("Expected no points for a long gap, found %d", len(points))
+	}
+	if points := fillGap(fillGap1, fillBefore, fillAfter, fillNone, cadence); len(points) != 0 {
+		t.Errorf("Expected no points without a fill method, found %d", len(points))
+	}
+}
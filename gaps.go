@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+var (
+	// source names where the dumps come from, gaps are tracked per source.
+	source = "cuit"
+	// cadence is how often a dump is expected from the source.
+	cadence = 15 * time.Minute
+)
+
+// gap is a run of consecutive dumps that never arrived.
+// Start and End are the first and last missing dump times.
+type gap struct {
+	Source  string
+	Start   time.Time
+	End     time.Time
+	Missing int
+}
+
+func init() {
+	commands["gaps"] = command{
+		description: "list missing dump intervals, -from and -to limit the range",
+		run:         gapsCommand,
+	}
+}
+
+// gapBetween finds the dumps missing between two consecutive dumps.
+// The bool returned is false if nothing is missing.
+func gapBetween(src string, prev, next time.Time, every time.Duration) (gap, bool) {
+	// round so small differences in the timestamps aren't counted as gaps
+	intervals := int((next.Sub(prev) + every/2) / every)
+	if intervals <= 1 {
+		return gap{}, false
+	}
+
+	return gap{
+		Source:  src,
+		Start:   prev.Add(every),
+		End:     next.Add(-every),
+		Missing: intervals - 1,
+	}, true
+}
+
+// times lists every missing dump time in the gap.
+func (g gap) times(every time.Duration) []time.Time {
+	times := make([]time.Time, 0, g.Missing)
+	for tm := g.Start; !tm.After(g.End); tm = tm.Add(every) {
+		times = append(times, tm)
+	}
+	return times
+}
+
+// recordDump notes that a dump from the source was ingested in the `dumps` table,
+// along with its format and format version.
+func recordDump(db *sql.DB, src string, dumpTime time.Time, filename string, summary ingestSummary) error {
+	version := sql.NullInt64{Int64: int64(summary.Version), Valid: summary.Version > 0}
+	_, err := db.Exec(`INSERT INTO dumps (source, dump_time, filename, row_count, format, format_version)
+		VALUES ($1, $2, $3, $4, $5, $6)`, src, dumpTime, filename, summary.Rows, summary.Format, version)
+	if err != nil {
+		return fmt.Errorf("Failed to record dump => {%s}", err)
+	}
+	return nil
+}
+
+// updateDump records what a reparsed dump was parsed as, and when, within the
+// transaction that replaces its density data.
+func updateDump(txn *sql.Tx, src string, dumpTime time.Time, summary ingestSummary) error {
+	version := sql.NullInt64{Int64: int64(summary.Version), Valid: summary.Version > 0}
+	_, err := txn.Exec(`UPDATE dumps SET row_count = $3, format = $4, format_version = $5, ingested_at = now()
+		WHERE source = $1 AND dump_time = $2`, src, dumpTime, summary.Rows, summary.Format, version)
+	if err != nil {
+		return fmt.Errorf("Failed to update dump => {%s}", err)
+	}
+	return nil
+}
+
+// updateGaps updates the `dump_gaps` table around a newly recorded dump.
+//
+// A dump that was resent splits the gap it used to be in, any other dump may start
+// a new gap after the previous dump. The gaps next to the dump are returned.
+func updateGaps(db *sql.DB, src string, dumpTime time.Time) ([]gap, error) {
+	var prev, next *time.Time
+	err := db.QueryRow(`SELECT
+		(SELECT MAX(dump_time) FROM dumps WHERE source = $1 AND dump_time < $2),
+		(SELECT MIN(dump_time) FROM dumps WHERE source = $1 AND dump_time > $2)`,
+		src, dumpTime).Scan(&prev, &next)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query surrounding dumps => {%s}", err)
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	_, err = txn.Exec(`DELETE FROM dump_gaps
+		WHERE source = $1 AND gap_start <= $2 AND gap_end >= $2`, src, dumpTime)
+	if err != nil {
+		txn.Rollback()
+		return nil, fmt.Errorf("Failed to remove filled gap => {%s}", err)
+	}
+
+	var found []gap
+	if prev != nil {
+		if g, exists := gapBetween(src, *prev, dumpTime, cadence); exists {
+			found = append(found, g)
+		}
+	}
+	if next != nil {
+		if g, exists := gapBetween(src, dumpTime, *next, cadence); exists {
+			found = append(found, g)
+		}
+	}
+
+	for _, g := range found {
+		_, err = txn.Exec(`INSERT INTO dump_gaps (source, gap_start, gap_end, missing_intervals)
+			VALUES ($1, $2, $3, $4)`, g.Source, g.Start, g.End, g.Missing)
+		if err != nil {
+			txn.Rollback()
+			return nil, fmt.Errorf("Failed to insert gap => {%s}", err)
+		}
+	}
+
+	return found, txn.Commit()
+}
+
+// loadGaps gets the gaps from the source overlapping the given range.
+func loadGaps(db *sql.DB, src string, from, to time.Time) ([]gap, error) {
+	rows, err := db.Query(`SELECT source, gap_start, gap_end, missing_intervals
+		FROM dump_gaps
+		WHERE source = $1 AND gap_end >= $2 AND gap_start <= $3
+		ORDER BY gap_start`, src, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query gaps => {%s}", err)
+	}
+	defer rows.Close()
+
+	var gaps []gap
+	for rows.Next() {
+		var g gap
+		if err = rows.Scan(&g.Source, &g.Start, &g.End, &g.Missing); err != nil {
+			return nil, fmt.Errorf("Failed to scan gap => {%s}", err)
+		}
+		gaps = append(gaps, g)
+	}
+	return gaps, rows.Err()
+}
+
+// gapsCommand prints every missing dump time in the range along with the filename
+// the dump should have had.
+func gapsCommand(args []string) error {
+	var (
+		flags  = newFlagSet("gaps")
+		period = rangeFlags(flags)
+	)
+	flags.Parse(args)
+
+	start, end, err := period.parse()
+	if err != nil {
+		return err
+	}
+
+	db := dbConnect()
+	defer db.Close()
+
+	gaps, err := loadGaps(db, source, start, end)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range gaps {
+		for _, tm := range g.times(cadence) {
+			if tm.Before(start) || tm.After(end) {
+				continue
+			}
+			tm = tm.In(NY)
+			fmt.Printf("%s\t%s\t%s.json\n", g.Source, tm.Format(time.RFC3339), tm.Format(datetimeFormat))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestGapBetween checks that missing dumps are counted between two dumps.
+func TestGapBetween(t *testing.T) {
+	prev := time.Date(2014, time.October, 31, 15, 0, 0, 0, NY)
+
+	if _, exists := gapBetween("cuit", prev, prev.Add(15*time.Minute), cadence); exists {
+		t.Error("Found a gap between consecutive dumps")
+	}
+
+	// a little late shouldn't count as missing
+	if _, exists := gapBetween("cuit", prev, prev.Add(20*time.Minute), cadence); exists {
+		t.Error("Found a gap for a late dump")
+	}
+
+	g, exists := gapBetween("cuit", prev, prev.Add(time.Hour), cadence)
+	if !exists {
+		t.Fatal("Failed to find gap")
+	}
+	expected := gap{
+		Source:  "cuit",
+		Start:   prev.Add(15 * time.Minute),
+		End:     prev.Add(45 * time.Minute),
+		Missing: 3,
+	}
+	if g != expected {
+		t.Errorf("Expected %#v, found %#v", expected, g)
+	}
+
+	times := g.times(cadence)
+	if len(times) != 3 || times[0] != expected.Start || times[2] != expected.End {
+		t.Errorf("Expected 3 missing times from the gap, found %v", times)
+	}
+}
+
+// TestParseTimeFlag checks both accepted time formats.
+func TestParseTimeFlag(t *testing.T) {
+	tm, err := parseTimeFlag("2014-10-11-15-45")
+	if err != nil || !tm.Equal(expectedTime) {
+		t.Errorf("Failed to parse filename format, found %s", tm)
+	}
+
+	tm, err = parseTimeFlag("2014-10-11")
+	if err != nil || !tm.Equal(time.Date(2014, time.October, 11, 0, 0, 0, 0, NY)) {
+		t.Errorf("Failed to parse date, found %s", tm)
+	}
+
+	if _, err = parseTimeFlag("yesterday"); err == nil {
+		t.Error("Invalid time parsed without failure")
+	}
+}
@@ -9,15 +9,16 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/howeyc/fsnotify"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var (
-	// example: 2014-10-31-15-15.json
-	filenameRegex = regexp.MustCompile(`(\d{4}(-\d{2}){4})\.json$`)
+	// example: 2014-10-31-15-15.json, any extension with a registered decoder is accepted
+	filenameRegex = regexp.MustCompile(`(\d{4}(-\d{2}){4})\.(json|ndjson|jsonl|csv|xml)$`)
 	datetimeRegex = regexp.MustCompile(`([\d-]*)`)
 	// datetimeFormat is the timestamp format used in the filenames.
 	datetimeFormat    = "2006-01-02-15-04"
@@ -27,8 +28,20 @@ var (
 		"day_window",
 		"week_window",
 		"month_window",
+		"filled_hour_window",
+		"breakdown_hour_window",
+		"breakdown_day_window",
+		"breakdown_week_window",
+		"breakdown_month_window",
 	}
-	PG_USER, PG_PASSWORD, PG_DB, PG_HOST, PG_PORT, PG_SSL string
+)
+
+// Postgres connection pool settings, configured by the command line flags.
+var (
+	maxOpenConns    = 10
+	maxIdleConns    = 2
+	connMaxLifetime = 30 * time.Minute
+	pingInterval    = time.Minute
 )
 
 // init is called on startup
@@ -41,16 +54,6 @@ func init() {
 
 }
 
-// configure runs before startup
-func configure() {
-	PG_USER = getOrElse("PG_USER", "adicu")
-	PG_PASSWORD = getOrElse("PG_PASSWORD", "")
-	PG_DB = getOrElse("PG_DB", "")
-	PG_HOST = getOrElse("PG_HOST", "localhost")
-	PG_PORT = getOrElse("PG_PORT", "5432")
-	PG_SSL = getOrElse("PG_SSL", "disable")
-}
-
 // getDate parses a filepath to get a date from the filename given the regex
 // declared in `filenameRegex`.
 func getDate(s string) (time.Time, error) {
@@ -60,70 +63,235 @@ func getDate(s string) (time.Time, error) {
 		NY)
 }
 
-// getOrElse checks the specified environment variable, returns the value if found, otherwise
-// will return the default value provided. If there is no default then makes a fatal log.
-func getOrElse(key, standard string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	} else if standard == "" {
-		log.Fatalf("ERROR: The environment variable, %s, must be set", key)
+// legacyVars are the PG_* variables, kept as a fallback for the standard PG*
+// variables read by pq, along with the keyword each sets and its default.
+var legacyVars = []struct {
+	legacy, standard, keyword, fallback string
+}{
+	{"PG_HOST", "PGHOST", "host", ""},
+	{"PG_PORT", "PGPORT", "port", ""},
+	{"PG_DB", "PGDATABASE", "dbname", ""},
+	{"PG_USER", "PGUSER", "user", "adicu"},
+	{"PG_PASSWORD", "PGPASSWORD", "password", ""},
+	{"PG_SSL", "PGSSLMODE", "sslmode", "disable"},
+}
+
+// postgresDSN builds the connection string from the environment.
+//
+// DATABASE_URL is used as is if it's set. Otherwise pq reads the standard PG*
+// variables, e.g. PGSSLROOTCERT or PGCONNECT_TIMEOUT, and the PG_* variables fill
+// in for any that aren't set. Without a password, PG_PASSWORD_FILE is read, then
+// pq falls back on PGPASSFILE or ~/.pgpass.
+func postgresDSN(getenv func(string) string) (string, error) {
+	password, err := readPasswordFile(getenv("PG_PASSWORD_FILE"))
+	if err != nil {
+		return "", err
+	}
+
+	if url := getenv("DATABASE_URL"); url != "" {
+		if password == "" || getenv("PGPASSWORD") != "" {
+			return url, nil
+		}
+		// the URL's own password takes precedence over the file
+		dsn := url
+		if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+			if dsn, err = pq.ParseURL(url); err != nil {
+				return "", fmt.Errorf("Invalid DATABASE_URL => %s", err.Error())
+			}
+		}
+		if strings.Contains(" "+dsn, " password=") {
+			return url, nil
+		}
+		return dsn + " password=" + quoteDSN(password), nil
 	}
-	return standard
+
+	var pairs []string
+	for _, v := range legacyVars {
+		if getenv(v.standard) != "" {
+			continue // pq reads the standard variable itself
+		}
+		value := getenv(v.legacy)
+		if value == "" && v.keyword == "password" {
+			value = password
+		}
+		if value == "" {
+			value = v.fallback
+		}
+		if value != "" {
+			pairs = append(pairs, v.keyword+"="+quoteDSN(value))
+		}
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// readPasswordFile reads a password from the first line of the file, if one's given.
+func readPasswordFile(filename string) (string, error) {
+	if filename == "" {
+		return "", nil
+	}
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read password file, %s => %s", filename, err.Error())
+	}
+	return strings.SplitN(strings.TrimRight(string(contents), "\r\n"), "\n", 2)[0], nil
+}
+
+// quoteDSN quotes a value of a key=value connection string, so it may contain spaces
+// and quotes.
+func quoteDSN(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
 }
 
 // dbConnect yanks db configurations from the environment variables and returns a postgres
 // connection
 func dbConnect() *sql.DB {
-	db, err := sql.Open("postgres",
-		fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%s sslmode=%s",
-			PG_USER,
-			PG_PASSWORD,
-			PG_DB,
-			PG_HOST,
-			PG_PORT,
-			PG_SSL,
-		))
+	dsn, err := postgresDSN(os.Getenv)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err.Error())
+	}
+
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		log.Fatalf("ERROR: Error connecting to Postgres => %s", err.Error())
 	}
-	log.Printf("PQ Database connection made to %s", PG_DB)
+
+	// connections are recycled before they go stale, any that break are replaced
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+
+	// fail fast on a bad DSN rather than on the first insert
+	var name string
+	if err = db.QueryRow("SELECT current_database()").Scan(&name); err != nil {
+		log.Fatalf("ERROR: Failed to reach Postgres => %s", err.Error())
+	}
+	log.Printf("PQ Database connection made to %s", name)
 	return db
 }
 
 // handleFile processes new files
 //
-// The file is read into memory, parsed then inserted to the database.
-func handleFile(filename string, db *sql.DB) {
+// The file is read into memory, parsed then written to the sink. Files of at least
+// `streamSize` are streamed into the sink instead, and archived from the file, if
+// both the sink and their format allow. Archiving, checks against previous dumps and
+// rejects are only kept by sinks that support them.
+func handleFile(filename string, sink Sink) {
 	log.Printf("Processing, %s", filename)
-	fileContents, err := ioutil.ReadFile(filename)
+	tm, err := getDate(filename)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse date from file, %s, ignored.", filename)
+		return
+	}
+
+	checker, checks := sink.(Checker)
+
+	info, err := os.Stat(filename)
 	if err != nil {
 		log.Printf("ERROR: Failed to read in file, %s => %s", filename, err.Error())
 		return
 	}
+	if streamer, ok := sink.(StreamWriter); ok && streamSize > 0 && info.Size() >= streamSize {
+		if checks {
+			checker.LoadLookups()
+		}
+
+		summary, err := streamer.WriteStream(filename, tm)
+		if archiver, ok := sink.(FileArchiver); ok && err != errNotStreamable {
+			if err := archiver.ArchiveFile(source, tm, filename); err != nil {
+				log.Printf("ERROR: Failed to archive, %s => %s", filename, err.Error())
+			}
+		}
+		writeRejects(sink, filename, summary.Rejects)
+		if err == nil {
+			log.Printf("Streamed %d groups from %s", summary.Rows, filename)
+			if checks {
+				checker.Record(filename, tm, summary)
+			}
+			return
+		} else if err != errNotStreamable {
+			log.Printf("ERROR: Failed to stream data from, %s => %s", filename, err.Error())
+			return
+		}
+		log.Printf("WARNING: %s can't be streamed, reading it into memory", filename)
+	}
 
-	tm, err := getDate(filename)
+	fileContents, err := ioutil.ReadFile(filename)
 	if err != nil {
-		log.Printf("ERROR: Failed to parse date from file, %s, ignored.", filename)
+		log.Printf("ERROR: Failed to read in file, %s => %s", filename, err.Error())
 		return
 	}
 
-	data, err := parseData(tm, fileContents)
+	// keep the original file around in case it needs to be parsed again
+	if archiver, ok := sink.(Archiver); ok {
+		if err = archiver.Archive(source, tm, filename, fileContents); err != nil {
+			log.Printf("ERROR: Failed to archive, %s => %s", filename, err.Error())
+		}
+	}
+
+	if checks {
+		checker.LoadLookups()
+	}
+
+	format, data, err := decodeFile(filename, tm, fileContents)
 	if err != nil {
 		log.Printf("ERROR: Failed to parse data from %s => %s", filename, err.Error())
 		return
 	}
 
-	if err = dataset(data).insert(db); err != nil {
+	data, rejects, err := data.validate(invalidPolicy)
+	writeRejects(sink, filename, rejects)
+	if err != nil {
+		log.Printf("ERROR: Rejected %s => %s", filename, err.Error())
+		return
+	} else if len(rejects) > 0 {
+		log.Printf("WARNING: %d invalid fields in %s, handled by the %s policy", len(rejects), filename, invalidPolicy)
+	}
+
+	data.validateAccessPoints()
+	data.estimateOccupancy()
+	var anomalies []anomaly
+	if checks {
+		anomalies = checker.CheckAnomalies(data, tm)
+	}
+
+	if err = sink.Write(data); err != nil {
 		log.Printf("ERROR: Failed to insert data from, %s => %s", filename, err.Error())
+		return
+	}
+
+	if checks {
+		checker.Record(filename, tm, data.summarize(format, anomalies, rejects))
+	}
+}
+
+// writeRejects keeps the invalid fields of a dump, if the sink supports it.
+func writeRejects(sink Sink, filename string, rejects []rejectedRecord) {
+	writer, ok := sink.(RejectWriter)
+	if !ok {
+		return
+	}
+	if err := writer.WriteRejects(source, filename, rejects); err != nil {
+		log.Printf("ERROR: Failed to insert rejects from, %s => %s", filename, err.Error())
+	}
+}
+
+// loadLookups refreshes the lookup tables used while ingesting from the database.
+// Failures are logged and the previously loaded values are kept.
+func loadLookups(db *sql.DB) {
+	if err := loadNameOverrides(db); err != nil {
+		log.Printf("ERROR: Failed to load name overrides => %s", err.Error())
+	}
+	if err := loadCapacities(db); err != nil {
+		log.Printf("ERROR: Failed to load capacities => %s", err.Error())
 	}
 }
 
 // Update the materialized views listed in `materializedViews`
-func updateViews(db *sql.DB) {
+func updateViews(db *sql.DB) error {
 	txn, err := db.Begin()
 	if err != nil {
 		log.Printf("ERROR: failed to start pq txn for materialized view updates => %s", err.Error())
-		return
+		return err
 	}
 
 	for _, view := range materializedViews {
@@ -136,13 +304,25 @@ func updateViews(db *sql.DB) {
 	if err != nil {
 		log.Printf("ERROR: Failed to commit transaction => {%s}", err)
 	}
+	return err
 }
 
-func LoadAllFiles(watchDir string) {
-	log.Printf("Loading all files in directory, %s", watchDir)
+// refresh brings the sink's aggregates up to date, logging any failure.
+func refresh(sink Sink) {
+	if err := sink.Refresh(); err != nil {
+		log.Printf("ERROR: Failed to refresh aggregates => %s", err.Error())
+	}
+}
 
-	db := dbConnect()
-	defer db.Close()
+// checkSink exits unless the sink can be written to, e.g. if the schema is behind.
+func checkSink(sink Sink) {
+	if err := sink.Health(); err != nil {
+		log.Fatalf("ERROR: Sink is unavailable => %s", err.Error())
+	}
+}
+
+func LoadAllFiles(watchDir string, sink Sink) {
+	log.Printf("Loading all files in directory, %s", watchDir)
 
 	files, err := ioutil.ReadDir(watchDir)
 	if err != nil {
@@ -151,13 +331,16 @@ func LoadAllFiles(watchDir string) {
 
 	// handle every data file
 	for _, f := range files {
-		handleFile(path.Join(watchDir, f.Name()), db)
+		if f.IsDir() {
+			continue
+		}
+		handleFile(path.Join(watchDir, f.Name()), sink)
 	}
 
-	updateViews(db) // refresh the materialized views afterwards
+	refresh(sink) // refresh the aggregates afterwards
 }
 
-func watchDirectory(watchDir string) {
+func watchDirectory(watchDir string, sink Sink) {
 	// start watching for new files
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -170,21 +353,46 @@ func watchDirectory(watchDir string) {
 		log.Fatalf("ERROR: Failed to start watching directory, %s => %s", watchDir, err.Error())
 	}
 
+	// enforce the retention rules on a schedule, if there are any for the sink
+	var retention <-chan time.Time
+	retainer, canRetain := sink.(Retainer)
+	if canRetain && (keepRawMonths > 0 || keepHourlyMonths > 0) {
+		retention = time.Tick(retentionInterval)
+	}
+
+	// ping the sink between dumps so an outage is noticed before the next one
+	var (
+		pings   = time.Tick(pingInterval)
+		healthy = true
+	)
+
 	// wait for any new files to be added, then process them
 	for {
 		select {
+		case <-retention:
+			if err := retainer.Retain(); err != nil {
+				log.Printf("ERROR: Failed to enforce retention rules => %s", err.Error())
+			}
+		case <-pings:
+			if err := sink.Health(); err != nil {
+				log.Printf("ERROR: Sink is unavailable => %s", err.Error())
+				healthy = false
+			} else if !healthy {
+				log.Printf("Sink is available again")
+				healthy = true
+			}
 		case event := <-watcher.Event:
-			// reconnect to the DB for each event because otherwise the connection gets stale
-			db := dbConnect()
-			if filenameRegex.MatchString(event.Name) {
+			// the pool replaces any broken connections, so the sink is only checked
+			if err := sink.Health(); err != nil {
+				log.Printf("ERROR: Sink is unavailable, %s ignored => %s", event.Name, err.Error())
+			} else if filenameRegex.MatchString(event.Name) {
 				// sleep to allow the whole file to be transmitted.
 				// otherwise we get a parsing error because it's incomplete.
 				time.Sleep(time.Duration(2 * time.Second))
 
-				handleFile(event.Name, db)
-				updateViews(db)
+				handleFile(event.Name, sink)
+				refresh(sink)
 			}
-			db.Close()
 		case err := <-watcher.Error:
 			log.Printf("ERROR: fsnotify err channel => {%s}", err)
 		}
@@ -192,24 +400,95 @@ func watchDirectory(watchDir string) {
 }
 
 func main() {
-	configure() // set up all configuration variables
-
 	// gather CLI configurations
 	var (
 		watchDir     = flag.String("dir", ".", "directory to watch for new files")
 		loadAll      = flag.Bool("all", false, "load all dump file in the directory")
 		keepWatching = flag.Bool("watch", true, "continue to watch for new files in the directory")
+		rulesFile    = flag.String("rules", "", "JSON file of rules for parsing floors from group names")
+		apGroupsFile = flag.String("ap-groups", "", "JSON file mapping access points to groups for controller exports")
 	)
+	flag.IntVar(&anomalyChecks.MaxCount, "anomaly-max", anomalyChecks.MaxCount, "highest client count accepted without flagging")
+	flag.IntVar(&anomalyChecks.MaxJump, "anomaly-jump", anomalyChecks.MaxJump, "largest change from the previous dump accepted without flagging")
+	flag.Float64Var(&anomalyChecks.ZScore, "anomaly-zscore", anomalyChecks.ZScore, "largest z-score against previous weeks accepted without flagging")
+	flag.IntVar(&anomalyChecks.Weeks, "anomaly-weeks", anomalyChecks.Weeks, "number of previous weeks used for the z-score")
+	flag.BoolVar(&anomalyChecks.Exclude, "exclude-anomalies", false, "exclude anomalous counts from the rollup views")
+	flag.DurationVar(&coverageWindow, "coverage-window", coverageWindow, "how recently a group must have been seen to be expected in a dump")
+	flag.IntVar(&missingWarnIntervals, "missing-intervals", missingWarnIntervals, "number of dumps a group can be missing before warning")
+	flag.StringVar(&source, "source", source, "name of where the dumps come from")
+	flag.DurationVar(&cadence, "cadence", cadence, "how often a dump is expected from the source")
+	flag.StringVar(&fillMethod, "fill", fillMethod, "how to fill gaps between dumps: none, linear or carry")
+	flag.IntVar(&maxFill, "max-fill", maxFill, "most missing dumps in a row that will be filled")
+	flag.StringVar(&sinkName, "sink", sinkName, "where to store the data: postgres, sqlite[:file], or memory to try out the parsers")
+	flag.StringVar(&invalidPolicy, "invalid", invalidPolicy, "what to do with invalid records: reject the file, skip them, or null their count")
+	flag.IntVar(&maxOpenConns, "db-max-open", maxOpenConns, "most connections open to Postgres at once")
+	flag.IntVar(&maxIdleConns, "db-max-idle", maxIdleConns, "most idle connections kept open to Postgres")
+	flag.DurationVar(&connMaxLifetime, "db-max-lifetime", connMaxLifetime, "how long a connection to Postgres is reused before it's replaced")
+	flag.DurationVar(&pingInterval, "ping-interval", pingInterval, "how often the sink is pinged while watching")
+	flag.IntVar(&keepRawMonths, "keep-raw-months", keepRawMonths, "months of raw data kept before it's downsampled to hourly, 0 to keep it forever")
+	flag.IntVar(&keepHourlyMonths, "keep-hourly-months", keepHourlyMonths, "months of hourly data kept, 0 to keep it forever")
+	flag.DurationVar(&retentionInterval, "retention-interval", retentionInterval, "how often the retention rules are enforced while watching")
+	flag.StringVar(&notifyChannel, "notify-channel", notifyChannel, "channel notified after each dump is ingested, empty to not notify")
+	flag.IntVar(&partitionsAhead, "partitions-ahead", partitionsAhead, "months of density_data partitions created ahead of each dump")
+	flag.Int64Var(&streamSize, "stream-size", streamSize, "size in bytes at which dump files are streamed rather than read into memory, 0 to never stream")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] [command [command flags]]\n", os.Args[0])
+		flag.PrintDefaults()
+		printCommands()
+	}
 	flag.Parse()
 
+	if !validFillMethod(fillMethod) {
+		log.Fatalf("ERROR: Unknown fill method, %s", fillMethod)
+	}
+
+	if !validSinkName(sinkName) {
+		log.Fatalf("ERROR: Unknown sink, %s", sinkName)
+	}
+
+	if !validInvalidPolicy(invalidPolicy) {
+		log.Fatalf("ERROR: Unknown invalid record policy, %s", invalidPolicy)
+	}
+
+	if !validRetention(keepRawMonths, keepHourlyMonths) {
+		log.Fatalf("ERROR: Invalid retention rules, hourly data must be kept at least as long as the raw data")
+	}
+
+	if *rulesFile != "" {
+		if err := loadNameRules(*rulesFile); err != nil {
+			log.Fatalf("ERROR: Failed to load name rules => %s", err.Error())
+		}
+	}
+
+	if *apGroupsFile != "" {
+		if err := loadAPGroups(*apGroupsFile); err != nil {
+			log.Fatalf("ERROR: Failed to load AP groups => %s", err.Error())
+		}
+	}
+
+	// run a command in place of loading files if one is given
+	if flag.NArg() > 0 {
+		runCommand(flag.Arg(0), flag.Args()[1:])
+		return
+	}
+
+	// a single sink is shared by loading and watching, refusing to ingest anything if
+	// it can't take it
+	var sink Sink
+	if *loadAll || *keepWatching {
+		sink = openSink()
+		defer sink.Close()
+		checkSink(sink)
+	}
+
 	// if all the files currently in the directory should be loaded
 	if *loadAll {
-		LoadAllFiles(*watchDir)
+		LoadAllFiles(*watchDir, sink)
 	}
 
 	// exits if flag turned on
 	if *keepWatching {
-		watchDirectory(*watchDir)
+		watchDirectory(*watchDir, sink)
 	}
 
 	// log because it's an unexpected answer
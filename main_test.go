@@ -1,6 +1,8 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 )
@@ -9,6 +11,7 @@ var (
 	testFilename     = "2014-10-11-15-45.json"
 	testFilename2    = "test_data/2014-10-11-15-45.json"
 	testFilenameFail = "201-10-11-1-45.json"
+	testFilenameCSV  = "2014-10-11-15-45.csv"
 	tz, _            = time.LoadLocation("America/New_York")
 	expectedTime     = time.Date(2014, time.October, 11, 15, 45, 0, 0, tz)
 )
@@ -22,6 +25,10 @@ func TestFilenameRegex(t *testing.T) {
 		t.Error("regex did not properly match")
 	}
 
+	if !filenameRegex.MatchString(testFilenameCSV) {
+		t.Error("regex did not properly match")
+	}
+
 	if filenameRegex.MatchString(testFilenameFail) {
 		t.Error("regex should not have matched")
 	}
@@ -43,3 +50,65 @@ func TestPullDate(t *testing.T) {
 		t.Error("Improper date parsed without failure")
 	}
 }
+
+var dsnTests = []struct {
+	env map[string]string
+	dsn string
+}{
+	{
+		map[string]string{"DATABASE_URL": "postgres://adicu:secret@db:5432/density?sslmode=verify-full", "PG_DB": "ignored"},
+		"postgres://adicu:secret@db:5432/density?sslmode=verify-full",
+	},
+	{
+		map[string]string{"PG_DB": "density", "PG_PASSWORD": `it's a \ secret`},
+		`dbname='density' user='adicu' password='it\'s a \\ secret' sslmode='disable'`,
+	},
+	{
+		map[string]string{"PG_DB": "density", "PGDATABASE": "other", "PGUSER": "analyst", "PGSSLMODE": "verify-full"},
+		"",
+	},
+	{
+		map[string]string{"PG_HOST": "db", "PGPASSWORD": "secret", "PG_PASSWORD": "ignored"},
+		`host='db' user='adicu' sslmode='disable'`,
+	},
+}
+
+// TestPostgresDSN checks that DATABASE_URL is used as is, and the standard PG*
+// variables take precedence over the PG_* ones.
+func TestPostgresDSN(t *testing.T) {
+	for _, tt := range dsnTests {
+		dsn, err := postgresDSN(func(key string) string { return tt.env[key] })
+		if err != nil {
+			t.Errorf("Failed to build DSN from %v => %s", tt.env, err)
+		} else if dsn != tt.dsn {
+			t.Errorf("Expected DSN %s from %v, found %s", tt.dsn, tt.env, dsn)
+		}
+	}
+}
+
+// TestPostgresDSNPasswordFile checks that the password file fills in for a missing password.
+func TestPostgresDSNPasswordFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from file\n")
+	file.Close()
+
+	env := map[string]string{"PG_PASSWORD_FILE": file.Name(), "PGDATABASE": "density", "PGUSER": "adicu", "PGSSLMODE": "disable"}
+	getenv := func(key string) string { return env[key] }
+	if dsn, _ := postgresDSN(getenv); dsn != "password='from file'" {
+		t.Errorf("Expected the password from the file, found %s", dsn)
+	}
+
+	env["DATABASE_URL"] = "postgres://adicu@db/density"
+	if dsn, _ := postgresDSN(getenv); dsn != "dbname='density' host='db' user='adicu' password='from file'" {
+		t.Errorf("Expected the password from the file added to the URL, found %s", dsn)
+	}
+
+	env["DATABASE_URL"] = "postgres://adicu:secret@db/density"
+	if dsn, _ := postgresDSN(getenv); dsn != env["DATABASE_URL"] {
+		t.Errorf("Expected the URL's own password, found %s", dsn)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands["migrate"] = command{
+		description: "apply or roll back the schema migrations: up, down or status",
+		run:         migrateCommand,
+	}
+}
+
+// migration is a change to the Postgres schema, Down undoes whatever Up does.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// latestVersion is the schema version the processor expects.
+func latestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// pendingMigrations are the migrations to apply, in order, to bring a database with
+// the applied versions up to the target version.
+func pendingMigrations(applied map[int]bool, target int) []migration {
+	var pending []migration
+	for _, m := range migrations {
+		if m.Version <= target && !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// rollbackMigrations are the migrations to undo, latest first, to bring a database
+// with the applied versions down to the target version.
+func rollbackMigrations(applied map[int]bool, target int) []migration {
+	var rollback []migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.Version > target && applied[m.Version] {
+			rollback = append(rollback, m)
+		}
+	}
+	return rollback
+}
+
+// checkSchemaVersion compares the version of a database to the latest, failing if
+// the database is behind.
+func checkSchemaVersion(version int) error {
+	switch latest := latestVersion(); {
+	case version < latest:
+		return fmt.Errorf("Database is at schema version %d, %d is needed, run `migrate up`", version, latest)
+	case version > latest:
+		log.Printf("WARNING: Database is at schema version %d, newer than %d", version, latest)
+	}
+	return nil
+}
+
+// ensureVersionTable creates the `schema_version` table if it's missing.
+//
+// A database created by the old `schema.sql` has the tables of the initial
+// migration, which is that schema, but no `schema_version`, it's adopted as being
+// at version 1.
+func ensureVersionTable(db *sql.DB) error {
+	var hasVersions, hasData bool
+	err := db.QueryRow(`SELECT
+		to_regclass('schema_version') IS NOT NULL,
+		to_regclass('density_data') IS NOT NULL`).Scan(&hasVersions, &hasData)
+	if err != nil {
+		return fmt.Errorf("Failed to look for schema_version => %s", err.Error())
+	}
+	if hasVersions {
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+	_, err = txn.Exec(`CREATE TABLE schema_version (
+		version     integer PRIMARY KEY,
+		name        text,
+		applied_at  timestamp with time zone DEFAULT now()
+	)`)
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to create schema_version => %s", err.Error())
+	}
+	if hasData {
+		log.Printf("Adopting existing schema as version %d, %s", migrations[0].Version, migrations[0].Name)
+		_, err = txn.Exec("INSERT INTO schema_version (version, name) VALUES ($1, $2)",
+			migrations[0].Version, migrations[0].Name)
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to adopt existing schema => %s", err.Error())
+		}
+	}
+	return txn.Commit()
+}
+
+// appliedMigrations loads when each applied migration was applied, by version.
+// Nothing has been applied if there's no `schema_version` table.
+func appliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('schema_version') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("Failed to look for schema_version => %s", err.Error())
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load schema versions => %s", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("Failed to scan schema version => %s", err.Error())
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// schemaVersion is the latest migration applied to the database, zero if none are.
+func schemaVersion(db *sql.DB) (int, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	var version int
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// appliedSet is the versions of the applied migrations.
+func appliedSet(applied map[int]time.Time) map[int]bool {
+	set := make(map[int]bool, len(applied))
+	for v := range applied {
+		set[v] = true
+	}
+	return set
+}
+
+// runMigration applies, or rolls back, a migration and records it in a single txn.
+func runMigration(db *sql.DB, m migration, up bool) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	stmt, record := m.Down, "DELETE FROM schema_version WHERE version = $1"
+	if up {
+		stmt, record = m.Up, "INSERT INTO schema_version (version, name) VALUES ($1, $2)"
+	}
+
+	if _, err = txn.Exec(stmt); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to migrate %d, %s => %s", m.Version, m.Name, err.Error())
+	}
+	args := []interface{}{m.Version}
+	if up {
+		args = append(args, m.Name)
+	}
+	if _, err = txn.Exec(record, args...); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("Failed to record migration %d => %s", m.Version, err.Error())
+	}
+	return txn.Commit()
+}
+
+// migrateUp applies every pending migration up to the target version.
+func migrateUp(db *sql.DB, target int) error {
+	if err := ensureVersionTable(db); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	pending := pendingMigrations(appliedSet(applied), target)
+	if len(pending) == 0 {
+		log.Printf("Schema is up to date")
+	}
+	for _, m := range pending {
+		if err = runMigration(db, m, true); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %d, %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// migrateDown rolls back every applied migration after the target version.
+func migrateDown(db *sql.DB, target int) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	rollback := rollbackMigrations(appliedSet(applied), target)
+	if len(rollback) == 0 {
+		log.Printf("Nothing to roll back")
+	}
+	for _, m := range rollback {
+		if err = runMigration(db, m, false); err != nil {
+			return err
+		}
+		log.Printf("Rolled back migration %d, %s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// downTarget is the version `migrate down` rolls back to from the current version,
+// the one before unless `-to` gives it. Rolling back the initial migration drops
+// every table, so it's only done when asked for with `-to=0`.
+func downTarget(current, to int) (int, error) {
+	if to >= 0 {
+		return to, nil
+	}
+	if current <= migrations[0].Version {
+		return 0, fmt.Errorf("Rolling back %d, %s, drops every table and all the data, run `migrate down -to=0` to do it",
+			migrations[0].Version, migrations[0].Name)
+	}
+	return current - 1, nil
+}
+
+// migrateCommand runs the migrations up to, or back to, the `-to` version, or
+// prints which are applied. Up defaults to the latest version, down to the one
+// before the current version, see downTarget.
+func migrateCommand(args []string) error {
+	var (
+		flags = newFlagSet("migrate")
+		to    = flags.Int("to", -1, "version to migrate up or down to, down to 0 drops everything (default: latest for up, the previous for down)")
+	)
+
+	action := "status"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+
+	switch action {
+	case "up", "down", "status":
+	default:
+		return fmt.Errorf("Unknown migrate action, %s, expected up, down or status", action)
+	}
+
+	db := dbConnect()
+	defer db.Close()
+
+	switch action {
+	case "up":
+		target := *to
+		if target < 0 {
+			target = latestVersion()
+		}
+		return migrateUp(db, target)
+
+	case "down":
+		current, err := schemaVersion(db)
+		if err != nil {
+			return err
+		}
+		target, err := downTarget(current, *to)
+		if err != nil {
+			return err
+		}
+		return migrateDown(db, target)
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		status := "pending"
+		if appliedAt, ok := applied[m.Version]; ok {
+			status = appliedAt.In(NY).Format(time.RFC3339)
+		}
+		fmt.Printf("%d\t%s\t%s\n", m.Version, m.Name, status)
+	}
+	return nil
+}
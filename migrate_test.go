@@ -0,0 +1,323 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMigrationsOrdered checks that the migrations are numbered in order from 1, and
+// each can be rolled back.
+func TestMigrationsOrdered(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to be version %d, found %d", i, i+1, m.Version)
+		}
+		if m.Name == "" || strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("Expected migration %d to have a name, up and down", m.Version)
+		}
+	}
+	if latestVersion() != len(migrations) {
+		t.Errorf("Expected latest version %d, found %d", len(migrations), latestVersion())
+	}
+}
+
+var migrationPlanTests = []struct {
+	applied  map[int]bool
+	target   int
+	up, down []int
+}{
+	{map[int]bool{}, 3, []int{1, 2, 3}, nil},
+	{map[int]bool{1: true}, 3, []int{2, 3}, nil},
+	{map[int]bool{1: true, 2: true}, 1, nil, []int{2}},
+	{map[int]bool{1: true, 2: true, 3: true}, 0, nil, []int{3, 2, 1}},
+	{map[int]bool{1: true, 3: true}, 2, []int{2}, []int{3}},
+	{map[int]bool{1: true, 2: true, 3: true}, 3, nil, nil},
+}
+
+// TestMigrationPlan checks which migrations are applied or rolled back to reach a version.
+func TestMigrationPlan(t *testing.T) {
+	defer func(original []migration) { migrations = original }(migrations)
+	migrations = []migration{
+		{Version: 1, Name: "one"},
+		{Version: 2, Name: "two"},
+		{Version: 3, Name: "three"},
+	}
+
+	versions := func(ms []migration) []int {
+		var v []int
+		for _, m := range ms {
+			v = append(v, m.Version)
+		}
+		return v
+	}
+	equal := func(a, b []int) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, tt := range migrationPlanTests {
+		if up := versions(pendingMigrations(tt.applied, tt.target)); !equal(up, tt.up) {
+			t.Errorf("Expected %v applied to reach %d from %v, found %v", tt.up, tt.target, tt.applied, up)
+		}
+		if down := versions(rollbackMigrations(tt.applied, tt.target)); !equal(down, tt.down) {
+			t.Errorf("Expected %v rolled back to reach %d from %v, found %v", tt.down, tt.target, tt.applied, down)
+		}
+	}
+}
+
+// TestCheckSchemaVersion checks that a database behind the migrations is refused.
+func TestCheckSchemaVersion(t *testing.T) {
+	if err := checkSchemaVersion(0); err == nil {
+		t.Error("Expected an empty database to be refused")
+	}
+	if err := checkSchemaVersion(latestVersion() - 1); err == nil {
+		t.Error("Expected a database behind the migrations to be refused")
+	}
+	if err := checkSchemaVersion(latestVersion()); err != nil {
+		t.Errorf("Expected the latest version to be accepted, found %s", err)
+	}
+	if err := checkSchemaVersion(latestVersion() + 1); err != nil {
+		t.Errorf("Expected a newer version to be accepted, found %s", err)
+	}
+}
+
+// baselineSchema is `schema.sql` as it was before the migrations, less its leading
+// DROP and the change of owner, the schema of the databases that are adopted.
+const baselineSchema = `
+CREATE TABLE density_data (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    client_count    integer,
+    PRIMARY KEY(dump_time, group_id)
+);
+
+CREATE INDEX ON density_data (group_id, dump_time);
+CREATE INDEX ON density_data (parent_id);
+
+CREATE MATERIALIZED VIEW hour_window AS (
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('hour', dump_time)
+);
+CREATE MATERIALIZED VIEW day_window AS (
+    SELECT
+        date_trunc('day', dump_time) AS day,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('day', dump_time)
+);
+
+CREATE MATERIALIZED VIEW week_window AS (
+    SELECT
+        date_trunc('week', dump_time) AS week,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('week', dump_time)
+);
+
+CREATE MATERIALIZED VIEW month_window AS (
+    SELECT
+        date_trunc('month', dump_time) AS month,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('month', dump_time)
+);
+`
+
+// TestInitialMigration checks that the initial migration is the baseline schema, so
+// adopting a database created by `schema.sql` as version 1 is right.
+func TestInitialMigration(t *testing.T) {
+	if strings.Join(strings.Fields(initialUp), " ") != strings.Join(strings.Fields(baselineSchema), " ") {
+		t.Errorf("Expected the initial migration to be the baseline schema, found\n%s", initialUp)
+	}
+}
+
+// TestMigrationsPostgres adopts a database created by the baseline `schema.sql`,
+// applies every migration to it with rows in each density table, then rolls them
+// all back. It needs an empty database, named by a DSN in TEST_DATABASE_URL, and
+// leaves it empty again.
+func TestMigrationsPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL isn't set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var empty bool
+	if err = db.QueryRow("SELECT to_regclass('density_data') IS NULL AND to_regclass('schema_version') IS NULL").Scan(&empty); err != nil {
+		t.Fatal(err)
+	}
+	if !empty {
+		t.Fatal("Expected an empty database in TEST_DATABASE_URL")
+	}
+	defer func() {
+		if err := migrateDown(db, 0); err != nil {
+			t.Error(err)
+		}
+		db.Exec("DROP TABLE IF EXISTS schema_version")
+	}()
+
+	for _, stmt := range []string{
+		baselineSchema,
+		`INSERT INTO density_data VALUES
+			('2014-10-31 15:15:00+00', 152, 'Lerner 3', 84, 'Lerner', 24),
+			('2014-11-01 15:15:00+00', 152, 'Lerner 3', 84, 'Lerner', 30)`,
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the baseline is adopted as version 1, then gains the ingest tables
+	if err = migrateUp(db, 2); err != nil {
+		t.Fatalf("Failed to migrate up from the baseline schema => %s", err)
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil || len(applied) != 2 {
+		t.Fatalf("Expected the baseline adopted as version 1 and 2 applied, found %v %v", applied, err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO ap_density (dump_time, group_id, ap_name, client_count) VALUES
+			('2014-10-31 15:15:00+00', 152, 'ap-1', 24),
+			('2014-11-01 15:15:00+00', 152, 'ap-1', 30)`,
+		`INSERT INTO client_breakdown (dump_time, group_id, dimension, label, client_count) VALUES
+			('2014-10-31 15:15:00+00', 152, 'band', '5GHz', 24)`,
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = migrateUp(db, latestVersion()); err != nil {
+		t.Fatalf("Failed to migrate up from the ingest tables => %s", err)
+	}
+	if version, err := schemaVersion(db); err != nil || version != latestVersion() {
+		t.Fatalf("Expected schema version %d, found %d %v", latestVersion(), version, err)
+	}
+	counts := map[string]int{
+		"density_data":         2,
+		"density_data_2014_10": 1,
+		"density_data_2014_11": 1,
+		"ap_density":           2,
+		"client_breakdown":     1,
+	}
+	for table, expected := range counts {
+		var count int
+		if err = db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+			t.Errorf("Failed to count %s => %s", table, err)
+		} else if count != expected {
+			t.Errorf("Expected %d rows in %s, found %d", expected, table, count)
+		}
+	}
+
+	var adopted int
+	if err = db.QueryRow("SELECT COUNT(*) FROM density_data WHERE NOT excluded AND parent_name = 'Lerner'").Scan(&adopted); err != nil || adopted != 2 {
+		t.Errorf("Expected the baseline rows kept and not excluded, found %d %v", adopted, err)
+	}
+
+	// a dump removed while partitioned leaves its access points behind
+	if _, err = db.Exec("DELETE FROM density_data WHERE dump_time = '2014-11-01 15:15:00+00'"); err != nil {
+		t.Fatal(err)
+	}
+	if err = migrateDown(db, 2); err != nil {
+		t.Fatalf("Failed to roll back to the ingest tables => %s", err)
+	}
+	var keys, orphans int
+	err = db.QueryRow(`SELECT COUNT(*) FROM pg_constraint WHERE contype = 'f'
+		AND conrelid IN ('ap_density'::regclass, 'client_breakdown'::regclass)`).Scan(&keys)
+	if err != nil || keys != 2 {
+		t.Errorf("Expected the 2 foreign keys to be restored, found %d %v", keys, err)
+	}
+	if err = db.QueryRow("SELECT COUNT(*) FROM ap_density WHERE dump_time = '2014-11-01 15:15:00+00'").Scan(&orphans); err != nil || orphans != 0 {
+		t.Errorf("Expected access points without density data to be removed, found %d %v", orphans, err)
+	}
+}
+
+var downTargetTests = []struct {
+	current, to, target int
+	valid               bool
+}{
+	{3, -1, 2, true},
+	{2, -1, 1, true},
+	{1, -1, 0, false},
+	{0, -1, 0, false},
+	{1, 0, 0, true},
+	{3, 1, 1, true},
+}
+
+// TestDownTarget checks that rolling back the initial migration must be asked for.
+func TestDownTarget(t *testing.T) {
+	for _, tt := range downTargetTests {
+		target, err := downTarget(tt.current, tt.to)
+		if (err == nil) != tt.valid || (tt.valid && target != tt.target) {
+			t.Errorf("Expected down from %d with -to=%d to reach %d (valid: %t), found %d %v",
+				tt.current, tt.to, tt.target, tt.valid, target, err)
+		}
+	}
+}
@@ -0,0 +1,1412 @@
+package main
+
+// migrations are the changes to the Postgres schema, in the order they're applied.
+// A migration is never edited once released, later changes to the schema are made
+// by appending another.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "initial",
+		Up:      initialUp,
+		Down:    initialDown,
+	},
+	{
+		Version: 2,
+		Name:    "ingest tables",
+		Up:      ingestUp,
+		Down:    ingestDown,
+	},
+	{
+		Version: 3,
+		Name:    "partition density_data",
+		Up:      partitionUp,
+		Down:    partitionDown,
+	},
+	{
+		Version: 4,
+		Name:    "downsampling",
+		Up:      downsampleUp,
+		Down:    downsampleDown,
+	},
+	{
+		Version: 5,
+		Name:    "raw dump chunks",
+		Up:      rawChunksUp,
+		Down:    rawChunksDown,
+	},
+	{
+		Version: 6,
+		Name:    "downsampled breakdowns and fills",
+		Up:      downsampleFillsUp,
+		Down:    downsampleFillsDown,
+	},
+}
+
+// initialUp is the schema as it stood in `schema.sql`, before the migrations, less
+// its leading DROP and the change of owner.
+const initialUp = `
+CREATE TABLE density_data (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    client_count    integer,
+    PRIMARY KEY(dump_time, group_id)
+);
+
+CREATE INDEX ON density_data (group_id, dump_time);
+CREATE INDEX ON density_data (parent_id);
+` + initialViews
+
+// initialViews are the windows of `schema.sql`, built on its `density_data`.
+const initialViews = `
+CREATE MATERIALIZED VIEW hour_window AS (
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('hour', dump_time)
+);
+CREATE MATERIALIZED VIEW day_window AS (
+    SELECT
+        date_trunc('day', dump_time) AS day,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('day', dump_time)
+);
+
+CREATE MATERIALIZED VIEW week_window AS (
+    SELECT
+        date_trunc('week', dump_time) AS week,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('week', dump_time)
+);
+
+CREATE MATERIALIZED VIEW month_window AS (
+    SELECT
+        date_trunc('month', dump_time) AS month,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count
+    FROM
+        density_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        date_trunc('month', dump_time)
+);
+`
+
+// initialDown drops everything created by initialUp.
+const initialDown = dropInitialViews + `
+DROP TABLE IF EXISTS density_data;
+`
+
+// dropInitialViews drops the windows of `schema.sql`.
+const dropInitialViews = `
+DROP MATERIALIZED VIEW IF EXISTS month_window;
+DROP MATERIALIZED VIEW IF EXISTS week_window;
+DROP MATERIALIZED VIEW IF EXISTS day_window;
+DROP MATERIALIZED VIEW IF EXISTS hour_window;
+`
+
+// ingestUp adds the locations, occupancy, anomaly exclusions and extras to
+// `density_data`, in the order they're in once it's partitioned, along with the
+// tables the ingest records everything else in. The windows are rebuilt with the
+// new columns.
+const ingestUp = dropInitialViews + `
+ALTER TABLE density_data
+    ADD COLUMN floor            integer,
+    ADD COLUMN wing             text,
+    ADD COLUMN zone             text,
+    ADD COLUMN estimated_occupancy real,
+    ADD COLUMN percent_full     real,
+    ADD COLUMN excluded         boolean DEFAULT false,
+    ADD COLUMN extras           jsonb;
+
+CREATE INDEX ON density_data (parent_id, floor);
+` + ingestTables + ingestViews
+
+// ingestTables are the tables added alongside `density_data` by ingestUp.
+const ingestTables = `
+-- counts for each access point of a group, when the dump breaks them down
+CREATE TABLE ap_density (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    ap_name         text,
+    client_count    integer,
+    PRIMARY KEY(dump_time, group_id, ap_name),
+    FOREIGN KEY(dump_time, group_id) REFERENCES density_data ON DELETE CASCADE
+);
+
+-- clients of a group split by a dimension: 'band', 'ssid' or 'auth'
+CREATE TABLE client_breakdown (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    dimension       text,
+    label           text,
+    client_count    integer,
+    PRIMARY KEY(dump_time, group_id, dimension, label),
+    FOREIGN KEY(dump_time, group_id) REFERENCES density_data ON DELETE CASCADE
+);
+
+-- locations for groups whose names the parsing rules can't handle
+CREATE TABLE group_name_overrides (
+    group_id        integer PRIMARY KEY,
+    floor           integer,
+    wing            text,
+    zone            text
+);
+
+-- size of each group's space, the latest effective_date on or before a dump applies
+CREATE TABLE group_capacity (
+    group_id            integer,
+    effective_date      date,
+    seats               integer,
+    devices_per_person  real DEFAULT 1,
+    PRIMARY KEY(group_id, effective_date)
+);
+
+-- suspicious counts found while ingesting
+CREATE TABLE anomalies (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    check_name      text,
+    client_count    integer,
+    expected        real,
+    detail          text,
+    PRIMARY KEY(dump_time, group_id, check_name)
+);
+
+-- groups missing from, or new to, each dump
+CREATE TABLE dump_coverage (
+    dump_time           timestamp with time zone,
+    group_id            integer,
+    status              text,
+    missing_intervals   integer,
+    PRIMARY KEY(dump_time, group_id)
+);
+
+-- every dump ingested from each source
+CREATE TABLE dumps (
+    source              text,
+    dump_time           timestamp with time zone,
+    filename            text,
+    row_count           integer,
+    format              text,
+    format_version      integer,
+    ingested_at         timestamp with time zone DEFAULT now(),
+    PRIMARY KEY(source, dump_time)
+);
+
+-- the original, gzipped, contents of each dump file for auditing and reparsing
+CREATE TABLE raw_dumps (
+    source              text,
+    dump_time           timestamp with time zone,
+    filename            text,
+    sha256              text,
+    size                integer,
+    contents            bytea,
+    archived_at         timestamp with time zone DEFAULT now(),
+    PRIMARY KEY(source, dump_time)
+);
+
+-- invalid fields of the records in each dump and whether the record was rejected, skipped or nulled
+CREATE TABLE rejects (
+    source              text,
+    dump_time           timestamp with time zone,
+    filename            text,
+    group_id            integer,
+    field               text,
+    message             text,
+    action              text,
+    record              text,
+    rejected_at         timestamp with time zone DEFAULT now()
+);
+
+CREATE INDEX ON rejects (dump_time);
+
+-- unrecognized keys found in the dumps, by the dump times they were first and last seen in
+CREATE TABLE extra_keys (
+    key                 text PRIMARY KEY,
+    first_seen          timestamp with time zone,
+    last_seen           timestamp with time zone,
+    first_ingested      timestamp with time zone DEFAULT now()
+);
+
+-- runs of dumps that never arrived, gap_start and gap_end are the first and last missing
+CREATE TABLE dump_gaps (
+    source              text,
+    gap_start           timestamp with time zone,
+    gap_end             timestamp with time zone,
+    missing_intervals   integer,
+    PRIMARY KEY(source, gap_start)
+);
+
+-- counts synthesized for short gaps, method is 'linear' or 'carry'
+CREATE TABLE interpolated_data (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    client_count    integer,
+    floor           integer,
+    wing            text,
+    zone            text,
+    estimated_occupancy real,
+    percent_full    real,
+    method          text,
+    PRIMARY KEY(dump_time, group_id)
+);
+`
+
+// ingestViews are the views once the ingest tables are added, all built on
+// `density_data`.
+const ingestViews = `
+-- real counts along with the synthesized ones, flagged as interpolated
+CREATE VIEW filled_data AS (
+    SELECT
+        dump_time,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        client_count,
+        floor,
+        wing,
+        zone,
+        estimated_occupancy,
+        percent_full,
+        false AS interpolated
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    UNION ALL
+    SELECT
+        dump_time,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        client_count,
+        floor,
+        wing,
+        zone,
+        estimated_occupancy,
+        percent_full,
+        true AS interpolated
+    FROM
+        interpolated_data
+);
+
+CREATE MATERIALIZED VIEW hour_window AS (
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('hour', dump_time)
+);
+CREATE MATERIALIZED VIEW day_window AS (
+    SELECT
+        date_trunc('day', dump_time) AS day,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('day', dump_time)
+);
+
+CREATE MATERIALIZED VIEW week_window AS (
+    SELECT
+        date_trunc('week', dump_time) AS week,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('week', dump_time)
+);
+
+CREATE MATERIALIZED VIEW month_window AS (
+    SELECT
+        date_trunc('month', dump_time) AS month,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('month', dump_time)
+);
+` + filledHourWindow + breakdownWindows
+
+// filledHourWindow rolls up the real and interpolated counts of `filled_data` by
+// hour.
+const filledHourWindow = `
+CREATE MATERIALIZED VIEW filled_hour_window AS (
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full,
+        SUM(CASE WHEN interpolated THEN 1 ELSE 0 END) AS interpolated_count
+    FROM
+        filled_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('hour', dump_time)
+);
+`
+
+// breakdownWindows roll up the breakdowns of the counts that aren't excluded.
+const breakdownWindows = `
+CREATE MATERIALIZED VIEW breakdown_hour_window AS (
+    SELECT
+        date_trunc('hour', b.dump_time) AS hour,
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        AVG(b.client_count) AS average_count,
+        MAX(b.client_count) AS max_count,
+        MIN(b.client_count) AS min_count
+    FROM
+        client_breakdown b
+        JOIN density_data d USING (dump_time, group_id)
+    WHERE
+        NOT d.excluded
+    GROUP BY
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        date_trunc('hour', b.dump_time)
+);
+
+CREATE MATERIALIZED VIEW breakdown_day_window AS (
+    SELECT
+        date_trunc('day', b.dump_time) AS day,
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        AVG(b.client_count) AS average_count,
+        MAX(b.client_count) AS max_count,
+        MIN(b.client_count) AS min_count
+    FROM
+        client_breakdown b
+        JOIN density_data d USING (dump_time, group_id)
+    WHERE
+        NOT d.excluded
+    GROUP BY
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        date_trunc('day', b.dump_time)
+);
+
+CREATE MATERIALIZED VIEW breakdown_week_window AS (
+    SELECT
+        date_trunc('week', b.dump_time) AS week,
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        AVG(b.client_count) AS average_count,
+        MAX(b.client_count) AS max_count,
+        MIN(b.client_count) AS min_count
+    FROM
+        client_breakdown b
+        JOIN density_data d USING (dump_time, group_id)
+    WHERE
+        NOT d.excluded
+    GROUP BY
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        date_trunc('week', b.dump_time)
+);
+
+CREATE MATERIALIZED VIEW breakdown_month_window AS (
+    SELECT
+        date_trunc('month', b.dump_time) AS month,
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        AVG(b.client_count) AS average_count,
+        MAX(b.client_count) AS max_count,
+        MIN(b.client_count) AS min_count
+    FROM
+        client_breakdown b
+        JOIN density_data d USING (dump_time, group_id)
+    WHERE
+        NOT d.excluded
+    GROUP BY
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        date_trunc('month', b.dump_time)
+);
+`
+
+// ingestDown drops everything added by ingestUp, restoring the windows of
+// `schema.sql`.
+const ingestDown = dropIngestViews + `
+DROP TABLE IF EXISTS interpolated_data;
+DROP TABLE IF EXISTS dump_gaps;
+DROP TABLE IF EXISTS extra_keys;
+DROP TABLE IF EXISTS rejects;
+DROP TABLE IF EXISTS raw_dumps;
+DROP TABLE IF EXISTS dumps;
+DROP TABLE IF EXISTS dump_coverage;
+DROP TABLE IF EXISTS anomalies;
+DROP TABLE IF EXISTS group_capacity;
+DROP TABLE IF EXISTS group_name_overrides;
+DROP TABLE IF EXISTS client_breakdown;
+DROP TABLE IF EXISTS ap_density;
+
+DROP INDEX IF EXISTS density_data_parent_id_floor_idx;
+ALTER TABLE density_data
+    DROP COLUMN floor,
+    DROP COLUMN wing,
+    DROP COLUMN zone,
+    DROP COLUMN estimated_occupancy,
+    DROP COLUMN percent_full,
+    DROP COLUMN excluded,
+    DROP COLUMN extras;
+` + initialViews
+
+// dropIngestViews drops the views of ingestViews.
+const dropIngestViews = `
+DROP MATERIALIZED VIEW IF EXISTS breakdown_month_window;
+DROP MATERIALIZED VIEW IF EXISTS breakdown_week_window;
+DROP MATERIALIZED VIEW IF EXISTS breakdown_day_window;
+DROP MATERIALIZED VIEW IF EXISTS breakdown_hour_window;
+DROP MATERIALIZED VIEW IF EXISTS filled_hour_window;
+DROP MATERIALIZED VIEW IF EXISTS month_window;
+DROP MATERIALIZED VIEW IF EXISTS week_window;
+DROP MATERIALIZED VIEW IF EXISTS day_window;
+DROP MATERIALIZED VIEW IF EXISTS hour_window;
+DROP VIEW IF EXISTS filled_data;
+`
+
+// partitionUp splits `density_data` into monthly partitions by dump time, moving
+// every existing row into the partition for its month. Rows outside every
+// partition land in `density_data_default`. The views are rebuilt on the
+// partitioned table.
+//
+// Postgres 11 can't reference a partitioned table, so the foreign keys of
+// `ap_density` and `client_breakdown` are dropped, their rows are removed along
+// with the density data by reparse and retention instead.
+const partitionUp = dropIngestViews + `
+ALTER TABLE ap_density DROP CONSTRAINT IF EXISTS ap_density_dump_time_group_id_fkey;
+ALTER TABLE client_breakdown DROP CONSTRAINT IF EXISTS client_breakdown_dump_time_group_id_fkey;
+
+ALTER TABLE density_data RENAME TO density_data_unpartitioned;
+ALTER TABLE density_data_unpartitioned RENAME CONSTRAINT density_data_pkey TO density_data_unpartitioned_pkey;
+DROP INDEX IF EXISTS density_data_group_id_dump_time_idx;
+DROP INDEX IF EXISTS density_data_parent_id_idx;
+DROP INDEX IF EXISTS density_data_parent_id_floor_idx;
+
+CREATE TABLE density_data (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    client_count    integer,
+    floor           integer,
+    wing            text,
+    zone            text,
+    estimated_occupancy real,
+    percent_full    real,
+    excluded        boolean DEFAULT false,
+    extras          jsonb,
+    PRIMARY KEY(dump_time, group_id)
+) PARTITION BY RANGE (dump_time);
+
+CREATE INDEX ON density_data (group_id, dump_time);
+CREATE INDEX ON density_data (parent_id);
+CREATE INDEX ON density_data (parent_id, floor);
+
+CREATE TABLE density_data_default PARTITION OF density_data DEFAULT;
+
+-- a partition for every UTC month with data, named as by partitionFormat
+DO $$
+DECLARE
+    month timestamp;
+BEGIN
+    FOR month IN
+        SELECT DISTINCT date_trunc('month', dump_time AT TIME ZONE 'UTC')
+        FROM density_data_unpartitioned
+    LOOP
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF density_data FOR VALUES FROM (%L) TO (%L)',
+            'density_data_' || to_char(month, 'YYYY_MM'),
+            month AT TIME ZONE 'UTC',
+            (month + interval '1 month') AT TIME ZONE 'UTC'
+        );
+    END LOOP;
+END
+$$;
+
+INSERT INTO density_data SELECT * FROM density_data_unpartitioned;
+DROP TABLE density_data_unpartitioned;
+` + ingestViews
+
+// partitionDown moves every row back into a single `density_data` table and
+// restores the foreign keys dropped by partitionUp, removing any access points or
+// breakdowns left without density data.
+const partitionDown = dropIngestViews + `
+ALTER TABLE density_data RENAME TO density_data_partitioned;
+ALTER TABLE density_data_partitioned RENAME CONSTRAINT density_data_pkey TO density_data_partitioned_pkey;
+DROP INDEX IF EXISTS density_data_group_id_dump_time_idx;
+DROP INDEX IF EXISTS density_data_parent_id_idx;
+DROP INDEX IF EXISTS density_data_parent_id_floor_idx;
+
+CREATE TABLE density_data (
+    dump_time       timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    client_count    integer,
+    floor           integer,
+    wing            text,
+    zone            text,
+    estimated_occupancy real,
+    percent_full    real,
+    excluded        boolean DEFAULT false,
+    extras          jsonb,
+    PRIMARY KEY(dump_time, group_id)
+);
+
+CREATE INDEX ON density_data (group_id, dump_time);
+CREATE INDEX ON density_data (parent_id);
+CREATE INDEX ON density_data (parent_id, floor);
+
+INSERT INTO density_data SELECT * FROM density_data_partitioned;
+DROP TABLE density_data_partitioned;
+
+DELETE FROM ap_density a WHERE NOT EXISTS (
+    SELECT 1 FROM density_data d WHERE d.dump_time = a.dump_time AND d.group_id = a.group_id
+);
+DELETE FROM client_breakdown b WHERE NOT EXISTS (
+    SELECT 1 FROM density_data d WHERE d.dump_time = b.dump_time AND d.group_id = b.group_id
+);
+ALTER TABLE ap_density ADD CONSTRAINT ap_density_dump_time_group_id_fkey
+    FOREIGN KEY(dump_time, group_id) REFERENCES density_data ON DELETE CASCADE;
+ALTER TABLE client_breakdown ADD CONSTRAINT client_breakdown_dump_time_group_id_fkey
+    FOREIGN KEY(dump_time, group_id) REFERENCES density_data ON DELETE CASCADE;
+` + ingestViews
+
+// downsampleUp adds `hourly_data` for the rollups of raw rows removed by the
+// retention rules, and rebuilds the density windows on both the raw and the
+// downsampled hours.
+const downsampleUp = `
+DROP MATERIALIZED VIEW IF EXISTS month_window;
+DROP MATERIALIZED VIEW IF EXISTS week_window;
+DROP MATERIALIZED VIEW IF EXISTS day_window;
+DROP MATERIALIZED VIEW IF EXISTS hour_window;
+
+-- hourly rollups of the density data kept once the raw rows are removed
+CREATE TABLE hourly_data (
+    hour            timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    floor           integer,
+    wing            text,
+    zone            text,
+    sample_count    bigint,
+    average_count   numeric,
+    max_count       integer,
+    min_count       integer,
+    average_occupancy    double precision,
+    average_percent_full double precision,
+    max_percent_full     real
+);
+
+CREATE INDEX ON hourly_data (hour, group_id);
+
+-- the raw density data rolled up by hour along with the downsampled hours,
+-- sample_count weighs each hour in the longer windows
+CREATE VIEW density_hours AS (
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        COUNT(client_count) AS sample_count,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('hour', dump_time)
+    UNION ALL
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        sample_count,
+        average_count,
+        max_count,
+        min_count,
+        average_occupancy,
+        average_percent_full,
+        max_percent_full
+    FROM
+        hourly_data
+);
+
+CREATE MATERIALIZED VIEW hour_window AS (
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        average_count,
+        max_count,
+        min_count,
+        average_occupancy,
+        average_percent_full,
+        max_percent_full
+    FROM
+        density_hours
+);
+CREATE MATERIALIZED VIEW day_window AS (
+    SELECT
+        date_trunc('day', hour) AS day,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count,
+        SUM(average_occupancy * sample_count) / NULLIF(SUM(sample_count), 0) AS average_occupancy,
+        SUM(average_percent_full * sample_count) / NULLIF(SUM(sample_count), 0) AS average_percent_full,
+        MAX(max_percent_full) AS max_percent_full
+    FROM
+        density_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('day', hour)
+);
+
+CREATE MATERIALIZED VIEW week_window AS (
+    SELECT
+        date_trunc('week', hour) AS week,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count,
+        SUM(average_occupancy * sample_count) / NULLIF(SUM(sample_count), 0) AS average_occupancy,
+        SUM(average_percent_full * sample_count) / NULLIF(SUM(sample_count), 0) AS average_percent_full,
+        MAX(max_percent_full) AS max_percent_full
+    FROM
+        density_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('week', hour)
+);
+
+CREATE MATERIALIZED VIEW month_window AS (
+    SELECT
+        date_trunc('month', hour) AS month,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count,
+        SUM(average_occupancy * sample_count) / NULLIF(SUM(sample_count), 0) AS average_occupancy,
+        SUM(average_percent_full * sample_count) / NULLIF(SUM(sample_count), 0) AS average_percent_full,
+        MAX(max_percent_full) AS max_percent_full
+    FROM
+        density_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('month', hour)
+);
+`
+
+// downsampleDown restores the density windows built on only the raw rows, the
+// downsampled hours are lost.
+const downsampleDown = `
+DROP MATERIALIZED VIEW IF EXISTS month_window;
+DROP MATERIALIZED VIEW IF EXISTS week_window;
+DROP MATERIALIZED VIEW IF EXISTS day_window;
+DROP MATERIALIZED VIEW IF EXISTS hour_window;
+DROP VIEW IF EXISTS density_hours;
+DROP TABLE IF EXISTS hourly_data;
+
+CREATE MATERIALIZED VIEW hour_window AS (
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('hour', dump_time)
+);
+
+CREATE MATERIALIZED VIEW day_window AS (
+    SELECT
+        date_trunc('day', dump_time) AS day,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('day', dump_time)
+);
+
+CREATE MATERIALIZED VIEW week_window AS (
+    SELECT
+        date_trunc('week', dump_time) AS week,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('week', dump_time)
+);
+
+CREATE MATERIALIZED VIEW month_window AS (
+    SELECT
+        date_trunc('month', dump_time) AS month,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full
+    FROM
+        density_data
+    WHERE
+        NOT excluded
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('month', dump_time)
+);
+`
+
+// rawChunksUp adds `raw_dump_chunks` for the compressed contents of dumps too large
+// to hold in memory, which are archived a chunk at a time with a NULL `contents`
+// in `raw_dumps`.
+const rawChunksUp = `
+ALTER TABLE raw_dumps ALTER COLUMN size TYPE bigint;
+
+CREATE TABLE raw_dump_chunks (
+    source              text,
+    dump_time           timestamp with time zone,
+    chunk               integer,
+    contents            bytea,
+    PRIMARY KEY(source, dump_time, chunk),
+    FOREIGN KEY(source, dump_time) REFERENCES raw_dumps ON DELETE CASCADE
+);
+`
+
+// rawChunksDown drops the chunked dumps, along with their rows in `raw_dumps`.
+const rawChunksDown = `
+DELETE FROM raw_dumps WHERE contents IS NULL;
+DROP TABLE IF EXISTS raw_dump_chunks;
+ALTER TABLE raw_dumps ALTER COLUMN size TYPE integer;
+`
+
+// downsampleFillsUp adds the hourly rollups of the breakdowns and interpolated counts
+// removed by the retention rules, alongside `hourly_data`, and rebuilds the
+// breakdown and filled windows on both the raw and the downsampled hours.
+const downsampleFillsUp = dropBreakdownWindows + `
+DROP MATERIALIZED VIEW IF EXISTS filled_hour_window;
+
+-- hourly rollups of the breakdowns kept once the raw rows are removed
+CREATE TABLE hourly_breakdown (
+    hour            timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    dimension       text,
+    label           text,
+    sample_count    bigint,
+    average_count   numeric,
+    max_count       integer,
+    min_count       integer
+);
+
+CREATE INDEX ON hourly_breakdown (hour, group_id);
+
+-- hourly rollups of the interpolated counts kept once the raw rows are removed
+CREATE TABLE hourly_interpolated (
+    hour            timestamp with time zone,
+    group_id        integer,
+    group_name      text,
+    parent_id       integer,
+    parent_name     text,
+    floor           integer,
+    wing            text,
+    zone            text,
+    sample_count    bigint,
+    average_count   numeric,
+    max_count       integer,
+    min_count       integer,
+    average_occupancy    double precision,
+    average_percent_full double precision,
+    max_percent_full     real
+);
+
+CREATE INDEX ON hourly_interpolated (hour, group_id);
+
+-- the raw breakdowns rolled up by hour along with the downsampled hours
+CREATE VIEW breakdown_hours AS (
+    SELECT
+        date_trunc('hour', b.dump_time) AS hour,
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        COUNT(b.client_count) AS sample_count,
+        AVG(b.client_count) AS average_count,
+        MAX(b.client_count) AS max_count,
+        MIN(b.client_count) AS min_count
+    FROM
+        client_breakdown b
+        JOIN density_data d USING (dump_time, group_id)
+    WHERE
+        NOT d.excluded
+    GROUP BY
+        b.group_id,
+        d.group_name,
+        d.parent_id,
+        d.parent_name,
+        b.dimension,
+        b.label,
+        date_trunc('hour', b.dump_time)
+    UNION ALL
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        sample_count,
+        average_count,
+        max_count,
+        min_count
+    FROM
+        hourly_breakdown
+);
+
+CREATE MATERIALIZED VIEW breakdown_hour_window AS (
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        average_count,
+        max_count,
+        min_count
+    FROM
+        breakdown_hours
+);
+
+CREATE MATERIALIZED VIEW breakdown_day_window AS (
+    SELECT
+        date_trunc('day', hour) AS day,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count
+    FROM
+        breakdown_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        date_trunc('day', hour)
+);
+
+CREATE MATERIALIZED VIEW breakdown_week_window AS (
+    SELECT
+        date_trunc('week', hour) AS week,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count
+    FROM
+        breakdown_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        date_trunc('week', hour)
+);
+
+CREATE MATERIALIZED VIEW breakdown_month_window AS (
+    SELECT
+        date_trunc('month', hour) AS month,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count
+    FROM
+        breakdown_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        dimension,
+        label,
+        date_trunc('month', hour)
+);
+
+-- the real and interpolated counts by hour, raw or downsampled, with the number of
+-- interpolated counts in each
+CREATE VIEW filled_hours AS (
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        sample_count,
+        average_count,
+        max_count,
+        min_count,
+        average_occupancy,
+        average_percent_full,
+        max_percent_full,
+        0 AS interpolated_count
+    FROM
+        density_hours
+    UNION ALL
+    SELECT
+        date_trunc('hour', dump_time) AS hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        COUNT(client_count) AS sample_count,
+        AVG(client_count) AS average_count,
+        MAX(client_count) AS max_count,
+        MIN(client_count) AS min_count,
+        AVG(estimated_occupancy) AS average_occupancy,
+        AVG(percent_full) AS average_percent_full,
+        MAX(percent_full) AS max_percent_full,
+        COUNT(*) AS interpolated_count
+    FROM
+        interpolated_data
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        date_trunc('hour', dump_time)
+    UNION ALL
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        sample_count,
+        average_count,
+        max_count,
+        min_count,
+        average_occupancy,
+        average_percent_full,
+        max_percent_full,
+        sample_count AS interpolated_count
+    FROM
+        hourly_interpolated
+);
+
+CREATE MATERIALIZED VIEW filled_hour_window AS (
+    SELECT
+        hour,
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        SUM(average_count * sample_count) / NULLIF(SUM(sample_count), 0) AS average_count,
+        MAX(max_count) AS max_count,
+        MIN(min_count) AS min_count,
+        SUM(average_occupancy * sample_count) / NULLIF(SUM(sample_count), 0) AS average_occupancy,
+        SUM(average_percent_full * sample_count) / NULLIF(SUM(sample_count), 0) AS average_percent_full,
+        MAX(max_percent_full) AS max_percent_full,
+        SUM(interpolated_count)::bigint AS interpolated_count
+    FROM
+        filled_hours
+    GROUP BY
+        group_id,
+        group_name,
+        parent_id,
+        parent_name,
+        floor,
+        wing,
+        zone,
+        hour
+);
+`
+
+// downsampleFillsDown restores the breakdown and filled windows built on only the
+// raw rows, the downsampled hours are lost.
+const downsampleFillsDown = dropBreakdownWindows + `
+DROP MATERIALIZED VIEW IF EXISTS filled_hour_window;
+DROP VIEW IF EXISTS filled_hours;
+DROP VIEW IF EXISTS breakdown_hours;
+DROP TABLE IF EXISTS hourly_interpolated;
+DROP TABLE IF EXISTS hourly_breakdown;
+` + filledHourWindow + breakdownWindows
+
+// dropBreakdownWindows drops the windows of the breakdowns.
+const dropBreakdownWindows = `
+DROP MATERIALIZED VIEW IF EXISTS breakdown_month_window;
+DROP MATERIALIZED VIEW IF EXISTS breakdown_week_window;
+DROP MATERIALIZED VIEW IF EXISTS breakdown_day_window;
+DROP MATERIALIZED VIEW IF EXISTS breakdown_hour_window;
+`
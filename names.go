@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+// nameRule describes how to pull a floor, wing and zone out of the group names of
+// a single building.
+//
+// Pattern may use the named capture groups `floor`, `wing` and `zone`. When a
+// group isn't captured, the static Floor, Wing or Zone value of the rule is used
+// instead.
+type nameRule struct {
+	ParentID int    `json:"parent_id"`
+	Pattern  string `json:"pattern"`
+	Floor    *int   `json:"floor,omitempty"`
+	Wing     string `json:"wing,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// groupLocation is the structured position of a group within its building.
+type groupLocation struct {
+	Floor sql.NullInt64
+	Wing  sql.NullString
+	Zone  sql.NullString
+}
+
+var (
+	// nameRules are tried in order for a group's parent building, the first match wins.
+	nameRules = mustCompileRules([]nameRule{
+		{ParentID: 146, Pattern: `^Architectural and Fine Arts Library (?P<floor>\d+)$`},
+		{ParentID: 103, Pattern: `^Butler Library (?P<floor>\d+)$`},
+		{ParentID: 103, Pattern: `^Butler Library stk$`, Zone: "Stacks"},
+		{ParentID: 79, Pattern: `^Lehman Library (?P<floor>\d+)$`},
+		{ParentID: 84, Pattern: `^Lerner (?P<floor>\d+)$`},
+		{ParentID: 84, Pattern: `^Roone Arledge Auditorium$`, Zone: "Auditorium"},
+	})
+
+	// nameOverrides holds locations for groups the rules can't handle, keyed by
+	// group ID. It is loaded from the `group_name_overrides` table.
+	nameOverrides = map[int]groupLocation{}
+)
+
+// compile prepares the rule's regular expression.
+func (r *nameRule) compile() error {
+	regex, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid pattern for parent %d, %s => {%s}", r.ParentID, r.Pattern, err)
+	}
+	r.regex = regex
+	return nil
+}
+
+// mustCompileRules compiles every rule and panics if any of them are invalid.
+func mustCompileRules(rules []nameRule) []nameRule {
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			panic(err)
+		}
+	}
+	return rules
+}
+
+// loadNameRules replaces the default rules with those in the given JSON file.
+// The file should hold an array of rules.
+func loadNameRules(filename string) error {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to read rules file, %s => {%s}", filename, err)
+	}
+
+	var rules []nameRule
+	if err = json.Unmarshal(contents, &rules); err != nil {
+		return fmt.Errorf("Failed to parse rules file, %s => {%s}", filename, err)
+	}
+	for i := range rules {
+		if err = rules[i].compile(); err != nil {
+			return err
+		}
+	}
+
+	nameRules = rules
+	return nil
+}
+
+// loadNameOverrides refreshes `nameOverrides` from the database.
+func loadNameOverrides(db *sql.DB) error {
+	rows, err := db.Query("SELECT group_id, floor, wing, zone FROM group_name_overrides")
+	if err != nil {
+		return fmt.Errorf("Failed to query name overrides => {%s}", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[int]groupLocation)
+	for rows.Next() {
+		var (
+			id  int
+			loc groupLocation
+		)
+		if err = rows.Scan(&id, &loc.Floor, &loc.Wing, &loc.Zone); err != nil {
+			return fmt.Errorf("Failed to scan name override => {%s}", err)
+		}
+		overrides[id] = loc
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("Failed to read name overrides => {%s}", err)
+	}
+
+	nameOverrides = overrides
+	return nil
+}
+
+// locateGroup works out where a group sits within its building.
+//
+// An override for the group ID always takes precedence over the rules. The bool
+// returned is false when neither an override nor a rule matched.
+func locateGroup(groupID, parentID int, name string) (groupLocation, bool) {
+	if loc, exists := nameOverrides[groupID]; exists {
+		return loc, true
+	}
+
+	for _, rule := range nameRules {
+		if rule.ParentID != parentID {
+			continue
+		}
+		match := rule.regex.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		var loc groupLocation
+		if rule.Floor != nil {
+			loc.Floor = sql.NullInt64{Int64: int64(*rule.Floor), Valid: true}
+		}
+		if rule.Wing != "" {
+			loc.Wing = sql.NullString{String: rule.Wing, Valid: true}
+		}
+		if rule.Zone != "" {
+			loc.Zone = sql.NullString{String: rule.Zone, Valid: true}
+		}
+
+		for i, group := range rule.regex.SubexpNames() {
+			if match[i] == "" {
+				continue
+			}
+			switch group {
+			case "floor":
+				floor, err := strconv.Atoi(match[i])
+				if err != nil {
+					continue
+				}
+				loc.Floor = sql.NullInt64{Int64: int64(floor), Valid: true}
+			case "wing":
+				loc.Wing = sql.NullString{String: match[i], Valid: true}
+			case "zone":
+				loc.Zone = sql.NullString{String: match[i], Valid: true}
+			}
+		}
+		return loc, true
+	}
+
+	return groupLocation{}, false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+var locateTests = []struct {
+	groupID  int
+	parentID int
+	name     string
+	expected groupLocation
+	matched  bool
+}{
+	{131, 103, "Butler Library 3", groupLocation{Floor: sql.NullInt64{Int64: 3, Valid: true}}, true},
+	{154, 84, "Lerner 5", groupLocation{Floor: sql.NullInt64{Int64: 5, Valid: true}}, true},
+	{150, 103, "Butler Library stk", groupLocation{Zone: sql.NullString{String: "Stacks", Valid: true}}, true},
+	{155, 75, "JJ's Place", groupLocation{}, false},
+	// rules only apply to their own building
+	{999, 84, "Butler Library 3", groupLocation{}, false},
+}
+
+// TestLocateGroup checks that the default rules extract floors and zones.
+func TestLocateGroup(t *testing.T) {
+	for _, tt := range locateTests {
+		loc, matched := locateGroup(tt.groupID, tt.parentID, tt.name)
+		if matched != tt.matched || loc != tt.expected {
+			t.Errorf("locateGroup(%q) = %#v, %t, expected %#v, %t",
+				tt.name, loc, matched, tt.expected, tt.matched)
+		}
+	}
+}
+
+// TestLocateGroupOverride checks that overrides take precedence over the rules.
+func TestLocateGroupOverride(t *testing.T) {
+	override := groupLocation{
+		Floor: sql.NullInt64{Int64: 0, Valid: true},
+		Wing:  sql.NullString{String: "South", Valid: true},
+	}
+	nameOverrides = map[int]groupLocation{155: override}
+	defer func() { nameOverrides = map[int]groupLocation{} }()
+
+	loc, matched := locateGroup(155, 75, "JJ's Place")
+	if !matched || loc != override {
+		t.Errorf("Override not applied, found %#v", loc)
+	}
+}
+
+// TestNamedGroups checks that wing and zone captures are used.
+func TestNamedGroups(t *testing.T) {
+	rule := nameRule{ParentID: 1, Pattern: `^Hall (?P<floor>\d+)(?P<wing>[NS]) (?P<zone>.+)$`}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	defaults := nameRules
+	nameRules = []nameRule{rule}
+	defer func() { nameRules = defaults }()
+
+	loc, matched := locateGroup(1, 1, "Hall 4N Reading Room")
+	if !matched || loc.Floor.Int64 != 4 || loc.Wing.String != "N" || loc.Zone.String != "Reading Room" {
+		t.Errorf("Failed to parse named groups, found %#v", loc)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	commands["listen"] = command{
+		description: "print the notifications sent as dumps are ingested, -channel picks the channel",
+		run:         listenCommand,
+	}
+}
+
+// notifyChannel is the channel notified after each dump is ingested and the views
+// refreshed, configured by the command line flags. Empty sends nothing.
+var notifyChannel = "density_dumps"
+
+// maxPayload is the largest payload Postgres accepts, in bytes.
+const maxPayload = 8000
+
+// dumpNotification is the JSON payload sent on the `notifyChannel` for a dump.
+// Groups are left out if listing them would make the payload too large.
+type dumpNotification struct {
+	DumpTime   time.Time `json:"dump_time"`
+	Rows       int       `json:"rows"`
+	GroupCount int       `json:"group_count"`
+	Groups     []int     `json:"groups,omitempty"`
+	Views      []string  `json:"refreshed_views"`
+}
+
+// newDumpNotification describes an ingested dump along with the refreshed views.
+func newDumpNotification(dumpTime time.Time, summary ingestSummary, views []string) dumpNotification {
+	groups := make([]int, 0, len(summary.Groups))
+	for id := range summary.Groups {
+		groups = append(groups, id)
+	}
+	sort.Ints(groups)
+
+	return dumpNotification{
+		DumpTime:   dumpTime,
+		Rows:       summary.Rows,
+		GroupCount: len(groups),
+		Groups:     groups,
+		Views:      views,
+	}
+}
+
+// payload encodes the notification, within the size Postgres accepts.
+func (n dumpNotification) payload() (string, error) {
+	encoded, err := json.Marshal(n)
+	if err == nil && len(encoded) >= maxPayload {
+		n.Groups = nil
+		encoded, err = json.Marshal(n)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to encode notification => %s", err.Error())
+	}
+	return string(encoded), nil
+}
+
+// notifyDumps sends a notification for each of the dumps.
+func notifyDumps(db *sql.DB, notifications []dumpNotification) error {
+	for _, n := range notifications {
+		payload, err := n.payload()
+		if err != nil {
+			return err
+		}
+		if _, err = db.Exec("SELECT pg_notify($1, $2)", notifyChannel, payload); err != nil {
+			return fmt.Errorf("Failed to notify %s => %s", notifyChannel, err.Error())
+		}
+	}
+	return nil
+}
+
+// listenCommand prints every notification on the channel until interrupted.
+func listenCommand(args []string) error {
+	var (
+		flags   = newFlagSet("listen")
+		channel = flags.String("channel", notifyChannel, "channel to listen on")
+	)
+	flags.Parse(args)
+
+	dsn, err := postgresDSN(os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("ERROR: Listener => %s", err.Error())
+		} else if event == pq.ListenerEventReconnected {
+			log.Printf("Listener reconnected, notifications may have been missed")
+		}
+	})
+	defer listener.Close()
+
+	if err = listener.Listen(*channel); err != nil {
+		return fmt.Errorf("Failed to listen on %s => %s", *channel, err.Error())
+	}
+	log.Printf("Listening on %s", *channel)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			if n != nil { // nil after reconnecting
+				fmt.Println(n.Extra)
+			}
+		case <-time.After(90 * time.Second):
+			// make sure the connection's still alive while it's quiet
+			go listener.Ping()
+		case <-interrupt:
+			return nil
+		}
+	}
+}
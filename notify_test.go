@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestDumpNotification checks the payload sent for an ingested dump.
+func TestDumpNotification(t *testing.T) {
+	dumpTime := time.Date(2014, time.October, 31, 15, 15, 0, 0, time.UTC)
+	summary := ingestSummary{Rows: 3, Groups: map[int]bool{152: true, 84: true, 130: true}}
+
+	payload, err := newDumpNotification(dumpTime, summary, []string{"hour_window"}).payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]interface{}
+	if err = json.Unmarshal([]byte(payload), &decoded); err != nil {
+		t.Fatalf("Failed to decode payload, %s => %s", payload, err)
+	}
+	expected := map[string]interface{}{
+		"dump_time":       "2014-10-31T15:15:00Z",
+		"rows":            float64(3),
+		"group_count":     float64(3),
+		"groups":          []interface{}{float64(84), float64(130), float64(152)},
+		"refreshed_views": []interface{}{"hour_window"},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected payload %v, found %v", expected, decoded)
+	}
+}
+
+// TestDumpNotificationTooLarge checks that the groups are left out rather than
+// exceeding the size Postgres accepts.
+func TestDumpNotificationTooLarge(t *testing.T) {
+	summary := ingestSummary{Rows: 5000, Groups: make(map[int]bool)}
+	for i := 0; i < 5000; i++ {
+		summary.Groups[100000+i] = true
+	}
+
+	payload, err := newDumpNotification(time.Now(), summary, materializedViews).payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) >= maxPayload {
+		t.Errorf("Expected a payload under %d bytes, found %d", maxPayload, len(payload))
+	}
+
+	var decoded dumpNotification
+	if err = json.Unmarshal([]byte(payload), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Groups != nil || decoded.GroupCount != 5000 {
+		t.Errorf("Expected only the count of 5000 groups, found %d listed and a count of %d", len(decoded.Groups), decoded.GroupCount)
+	}
+}
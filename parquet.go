@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// parquetRowGroup is the number of rows buffered before they're written as a row
+// group.
+var parquetRowGroup = 100000
+
+// parquet physical types
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// parquet converted types, for readers that don't know the logical types
+const (
+	parquetNoConverted     = -1
+	parquetUTF8            = 0
+	parquetTimestampMicros = 10
+	parquetJSON            = 19
+)
+
+// parquet logical types, the field of each in the LogicalType union
+const (
+	parquetNoLogical = 0
+	parquetString    = 1
+	parquetTimestamp = 8
+	parquetJSONType  = 12
+)
+
+// parquet encodings
+const (
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Parquet metadata with the Thrift compact protocol.
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16 // last field ID of each struct being written
+}
+
+// newThriftWriter begins writing a struct.
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	t.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+// field writes the header of a field, as a delta from the last if it can.
+func (t *thriftWriter) field(id int16, kind byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) string(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.uvarint(uint64(len(v)))
+	t.WriteString(v)
+}
+
+// list writes the header of a list of n elements of the kind.
+func (t *thriftWriter) list(id int16, kind byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | kind)
+	} else {
+		t.WriteByte(0xf0 | kind)
+		t.uvarint(uint64(n))
+	}
+}
+
+// beginStruct begins a struct, as a field, or as an element of a list if id is 0.
+func (t *thriftWriter) beginStruct(id int16) {
+	if id != 0 {
+		t.field(id, thriftStruct)
+	}
+	t.last = append(t.last, 0)
+}
+
+// endStruct ends a struct begun with beginStruct, or the writer's own.
+func (t *thriftWriter) endStruct() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// parquetColumn buffers the values of a column for the row group being written.
+type parquetColumn struct {
+	name      string
+	physical  int32
+	converted int32
+	logical   int16
+	utc       bool // whether a timestamp is adjusted to UTC
+
+	defined []bool // whether each row has a value
+	values  bytes.Buffer
+	bools   []bool
+}
+
+// newParquetColumn maps a Postgres type, as named by database/sql, to a Parquet
+// type. Numerics are written as doubles, and anything unknown as a string.
+//
+// The TIMESTAMP_MICROS converted type means a UTC instant, so a timestamp without
+// a time zone only has the logical type, marked as not adjusted to UTC.
+func newParquetColumn(name, dbType string) *parquetColumn {
+	c := &parquetColumn{name: name, converted: parquetNoConverted}
+	switch dbType {
+	case "TIMESTAMPTZ":
+		c.physical, c.converted, c.logical, c.utc = parquetInt64, parquetTimestampMicros, parquetTimestamp, true
+	case "TIMESTAMP":
+		c.physical, c.logical = parquetInt64, parquetTimestamp
+	case "INT2", "INT4":
+		c.physical = parquetInt32
+	case "INT8":
+		c.physical = parquetInt64
+	case "FLOAT4":
+		c.physical = parquetFloat
+	case "FLOAT8", "NUMERIC":
+		c.physical = parquetDouble
+	case "BOOL":
+		c.physical = parquetBoolean
+	case "JSON", "JSONB":
+		c.physical, c.converted, c.logical = parquetByteArray, parquetJSON, parquetJSONType
+	default:
+		c.physical, c.converted, c.logical = parquetByteArray, parquetUTF8, parquetString
+	}
+	return c
+}
+
+// add a value, as scanned by database/sql, to the column.
+func (c *parquetColumn) add(value interface{}) error {
+	if value == nil {
+		c.defined = append(c.defined, false)
+		return nil
+	}
+
+	var err error
+	switch c.physical {
+	case parquetBoolean:
+		v, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("Expected a boolean for %s, found %T", c.name, value)
+		}
+		c.bools = append(c.bools, v)
+	case parquetInt32, parquetInt64:
+		var v int64
+		switch n := value.(type) {
+		case int64:
+			v = n
+		case time.Time:
+			v = n.UnixNano() / int64(time.Microsecond)
+		case []byte:
+			v, err = strconv.ParseInt(string(n), 10, 64)
+		default:
+			err = fmt.Errorf("Expected an integer for %s, found %T", c.name, value)
+		}
+		if err == nil && c.physical == parquetInt32 && (v < math.MinInt32 || v > math.MaxInt32) {
+			err = fmt.Errorf("%d is out of range for %s", v, c.name)
+		}
+		if err != nil {
+			return err
+		}
+		if c.physical == parquetInt32 {
+			binary.Write(&c.values, binary.LittleEndian, int32(v))
+		} else {
+			binary.Write(&c.values, binary.LittleEndian, v)
+		}
+	case parquetFloat, parquetDouble:
+		var v float64
+		switch n := value.(type) {
+		case float64:
+			v = n
+		case int64:
+			v = float64(n)
+		case []byte:
+			v, err = strconv.ParseFloat(string(n), 64)
+		default:
+			err = fmt.Errorf("Expected a number for %s, found %T", c.name, value)
+		}
+		if err != nil {
+			return err
+		}
+		if c.physical == parquetFloat {
+			binary.Write(&c.values, binary.LittleEndian, math.Float32bits(float32(v)))
+		} else {
+			binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+		}
+	default:
+		var v string
+		switch s := value.(type) {
+		case string:
+			v = s
+		case []byte:
+			v = string(s)
+		case time.Time:
+			v = s.Format(time.RFC3339Nano)
+		default:
+			v = fmt.Sprint(s)
+		}
+		binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+		c.values.WriteString(v)
+	}
+	c.defined = append(c.defined, true)
+	return nil
+}
+
+// bitPack packs the bits LSB first, padded to whole bytes.
+func bitPack(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
+
+// page encodes the buffered values as a data page, the definition levels as a
+// single bit packed run followed by the plain encoded values.
+func (c *parquetColumn) page() []byte {
+	var levels bytes.Buffer
+	var buf [binary.MaxVarintLen64]byte
+	packed := bitPack(c.defined)
+	levels.Write(buf[:binary.PutUvarint(buf[:], uint64(len(packed))<<1|1)])
+	levels.Write(packed)
+
+	var page bytes.Buffer
+	binary.Write(&page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+	if c.physical == parquetBoolean {
+		page.Write(bitPack(c.bools))
+	} else {
+		page.Write(c.values.Bytes())
+	}
+	return page.Bytes()
+}
+
+// reset the column for the next row group.
+func (c *parquetColumn) reset() {
+	c.defined = c.defined[:0]
+	c.values.Reset()
+	c.bools = c.bools[:0]
+}
+
+// parquetChunk is the metadata of a column chunk that's been written.
+type parquetChunk struct {
+	offset, size int64
+	values       int
+}
+
+// parquetWriter writes rows to a Parquet file. Every column is optional and
+// written uncompressed, with a single data page per column chunk.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []*parquetColumn
+	rows      int // in the row group being buffered
+	total     int64
+	rowGroups [][]parquetChunk
+}
+
+// newParquetWriter begins a file with a column of each name and Postgres type.
+func newParquetWriter(w io.Writer, names, types []string) (*parquetWriter, error) {
+	p := &parquetWriter{w: w}
+	for i, name := range names {
+		p.columns = append(p.columns, newParquetColumn(name, types[i]))
+	}
+	return p, p.write([]byte("PAR1"))
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// Write adds a row, writing a row group once enough are buffered.
+func (p *parquetWriter) Write(values []interface{}) error {
+	for i, c := range p.columns {
+		if err := c.add(values[i]); err != nil {
+			return err
+		}
+	}
+	p.rows++
+	if p.rows >= parquetRowGroup {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	chunks := make([]parquetChunk, len(p.columns))
+	for i, c := range p.columns {
+		page := c.page()
+
+		header := newThriftWriter()
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.beginStruct(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunks[i] = parquetChunk{offset: p.offset, size: int64(header.Len() + len(page)), values: p.rows}
+		if err := p.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(page); err != nil {
+			return err
+		}
+		c.reset()
+	}
+	p.rowGroups = append(p.rowGroups, chunks)
+	p.total += int64(p.rows)
+	p.rows = 0
+	return nil
+}
+
+// schema writes the schema elements, the root followed by each column.
+func (p *parquetWriter) schema(t *thriftWriter) {
+	t.list(2, thriftStruct, len(p.columns)+1)
+	t.beginStruct(0)
+	t.string(4, "schema")
+	t.i32(5, int32(len(p.columns)))
+	t.endStruct()
+
+	for _, c := range p.columns {
+		t.beginStruct(0)
+		t.i32(1, c.physical)
+		t.i32(3, 1) // OPTIONAL
+		t.string(4, c.name)
+		if c.converted != parquetNoConverted {
+			t.i32(6, c.converted)
+		}
+		if c.logical != parquetNoLogical {
+			t.beginStruct(10)
+			t.beginStruct(c.logical)
+			if c.logical == parquetTimestamp {
+				t.bool(1, c.utc)
+				t.beginStruct(2)
+				t.beginStruct(2) // MICROS
+				t.endStruct()
+				t.endStruct()
+			}
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+}
+
+// Close writes any buffered rows and the footer. It doesn't close the underlying
+// writer.
+func (p *parquetWriter) Close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	t := newThriftWriter()
+	t.i32(1, 1)
+	p.schema(t)
+	t.i64(3, p.total)
+	t.list(4, thriftStruct, len(p.rowGroups))
+	for _, chunks := range p.rowGroups {
+		t.beginStruct(0)
+		t.list(1, thriftStruct, len(chunks))
+		var size int64
+		for i, chunk := range chunks {
+			c := p.columns[i]
+			t.beginStruct(0)
+			t.i64(2, chunk.offset)
+			t.beginStruct(3)
+			t.i32(1, c.physical)
+			t.list(2, thriftI32, 2)
+			t.varint(parquetPlain)
+			t.varint(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.uvarint(uint64(len(c.name)))
+			t.WriteString(c.name)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, int64(chunk.values))
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.endStruct()
+			t.endStruct()
+			size += chunk.size
+		}
+		t.i64(2, size)
+		t.i64(3, int64(chunks[0].values))
+		t.endStruct()
+	}
+	t.string(6, "wireless_data_processor")
+	t.endStruct()
+
+	if err := p.write(t.Bytes()); err != nil {
+		return err
+	}
+	var footer [8]byte
+	binary.LittleEndian.PutUint32(footer[:4], uint32(t.Len()))
+	copy(footer[4:], "PAR1")
+	return p.write(footer[:])
+}
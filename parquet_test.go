@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestThriftWriter checks the compact encoding of short and long field deltas.
+func TestThriftWriter(t *testing.T) {
+	w := newThriftWriter()
+	w.i32(1, 1)
+	w.string(4, "ab")
+	w.i64(20, -1)
+	w.endStruct()
+
+	expected := []byte{0x15, 0x02, 0x38, 0x02, 'a', 'b', 0x06, 0x28, 0x01, 0x00}
+	if !bytes.Equal(w.Bytes(), expected) {
+		t.Errorf("Expected % x, found % x", expected, w.Bytes())
+	}
+}
+
+// TestParquetPage checks that nulls are left out of the values and marked in the
+// definition levels.
+func TestParquetPage(t *testing.T) {
+	c := newParquetColumn("client_count", "INT4")
+	for _, v := range []interface{}{int64(5), nil, int64(7)} {
+		if err := c.add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []byte{2, 0, 0, 0, 0x03, 0x05, 5, 0, 0, 0, 7, 0, 0, 0}
+	if page := c.page(); !bytes.Equal(page, expected) {
+		t.Errorf("Expected % x, found % x", expected, page)
+	}
+
+	if err := c.add(int64(1) << 40); err == nil {
+		t.Error("Expected a value out of range of an INT4 to be an error")
+	}
+}
+
+// TestParquetTimestamp checks that timestamps are written as microseconds.
+func TestParquetTimestamp(t *testing.T) {
+	c := newParquetColumn("dump_time", "TIMESTAMPTZ")
+	if err := c.add(time.Date(2014, 10, 31, 11, 0, 0, 0, tz)); err != nil {
+		t.Fatal(err)
+	}
+	var micros int64
+	binary.Read(bytes.NewReader(c.values.Bytes()), binary.LittleEndian, &micros)
+	if micros != 1414767600000000 {
+		t.Errorf("Expected 1414767600000000 microseconds, found %d", micros)
+	}
+}
+
+// TestParquetFile checks the file is framed by the magic with the footer's length
+// before the last.
+func TestParquetFile(t *testing.T) {
+	var buf bytes.Buffer
+	e := newParquetExporter(&buf)
+	writeExport(t, e)
+
+	file := buf.Bytes()
+	if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+		t.Fatalf("Expected the file to begin and end with PAR1, found % x", file)
+	}
+	length := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	if length <= 0 || length > len(file)-12 {
+		t.Fatalf("Expected a footer within the file, found a length of %d", length)
+	}
+	footer := file[len(file)-8-length : len(file)-8]
+	if !bytes.HasPrefix(footer, []byte{0x15, 0x02}) {
+		t.Errorf("Expected the footer to begin with version 1, found % x", footer[:2])
+	}
+	if e.p.total != int64(len(exportRowsIn)) {
+		t.Errorf("Expected %d rows, found %d", len(exportRowsIn), e.p.total)
+	}
+}
+
+// thriftReader decodes the Thrift compact protocol, as much of it as the Parquet
+// metadata needs, into maps of field IDs to values. Integers are decoded as int64
+// and binaries as strings.
+type thriftReader struct {
+	*bytes.Reader
+	err error
+}
+
+func (r *thriftReader) byte() byte {
+	b, err := r.ReadByte()
+	if r.err == nil {
+		r.err = err
+	}
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, err := binary.ReadUvarint(r)
+	if r.err == nil {
+		r.err = err
+	}
+	return v
+}
+
+func (r *thriftReader) varint() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// value decodes a value of the kind.
+func (r *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		b := make([]byte, r.uvarint())
+		if _, err := r.Read(b); err != nil && r.err == nil {
+			r.err = err
+		}
+		return string(b)
+	case thriftList:
+		header := r.byte()
+		n := uint64(header >> 4)
+		if n == 15 {
+			n = r.uvarint()
+		}
+		list := []interface{}{}
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			list = append(list, r.value(header&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return r.fields()
+	}
+	if r.err == nil {
+		r.err = fmt.Errorf("Unexpected thrift type %d", kind)
+	}
+	return nil
+}
+
+// fields decodes a struct.
+func (r *thriftReader) fields() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	var id int16
+	for r.err == nil {
+		header := r.byte()
+		if header == 0 {
+			break
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.varint())
+		}
+		fields[id] = r.value(header & 0x0f)
+	}
+	return fields
+}
+
+// readParquet decodes a file written by parquetWriter, returning its metadata and
+// the values of each column, nil for nulls.
+func readParquet(t *testing.T, file []byte) (map[int16]interface{}, [][]interface{}) {
+	length := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	r := &thriftReader{Reader: bytes.NewReader(file[len(file)-8-length : len(file)-8])}
+	meta := r.fields()
+	if r.err != nil {
+		t.Fatalf("Failed to decode the footer => %s", r.err)
+	}
+
+	schema := meta[2].([]interface{})
+	columns := make([][]interface{}, len(schema)-1)
+	for _, rowGroup := range meta[4].([]interface{}) {
+		for i, chunk := range rowGroup.(map[int16]interface{})[1].([]interface{}) {
+			chunkMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			offset := chunkMeta[9].(int64)
+
+			r = &thriftReader{Reader: bytes.NewReader(file[offset:])}
+			header := r.fields()
+			if r.err != nil {
+				t.Fatalf("Failed to decode a page header => %s", r.err)
+			}
+			start := len(file[offset:]) - r.Len()
+			page := file[offset+int64(start) : offset+int64(start)+header[3].(int64)]
+			rows := int(header[5].(map[int16]interface{})[1].(int64))
+			columns[i] = append(columns[i], readParquetPage(t, chunkMeta[1].(int64), rows, page)...)
+		}
+	}
+	return meta, columns
+}
+
+// readParquetPage decodes the definition levels and plain encoded values of a
+// data page.
+func readParquetPage(t *testing.T, physical int64, rows int, page []byte) []interface{} {
+	r := bytes.NewReader(page[4 : 4+binary.LittleEndian.Uint32(page)])
+	var defined []bool
+	for r.Len() > 0 {
+		header, _ := binary.ReadUvarint(r)
+		if header&1 == 1 {
+			for i := uint64(0); i < header>>1; i++ {
+				b, _ := r.ReadByte()
+				for bit := uint(0); bit < 8; bit++ {
+					defined = append(defined, b&(1<<bit) != 0)
+				}
+			}
+		} else {
+			b, _ := r.ReadByte()
+			for i := uint64(0); i < header>>1; i++ {
+				defined = append(defined, b == 1)
+			}
+		}
+	}
+	if len(defined) < rows {
+		t.Fatalf("Expected %d definition levels, found %d", rows, len(defined))
+	}
+
+	values := page[4+binary.LittleEndian.Uint32(page):]
+	column := make([]interface{}, rows)
+	n := 0
+	for i := range column {
+		if !defined[i] {
+			continue
+		}
+		switch physical {
+		case parquetBoolean:
+			column[i] = values[n/8]&(1<<uint(n%8)) != 0
+		case parquetInt32:
+			column[i] = int64(int32(binary.LittleEndian.Uint32(values)))
+			values = values[4:]
+		case parquetInt64:
+			column[i] = int64(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetFloat:
+			column[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(values)))
+			values = values[4:]
+		case parquetDouble:
+			column[i] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		default:
+			size := binary.LittleEndian.Uint32(values)
+			column[i] = string(values[4 : 4+size])
+			values = values[4+size:]
+		}
+		n++
+	}
+	return column
+}
+
+// parquetGolden is the file TestParquetRoundTrip writes, as read by parquet-go with
+// test_data/parquet/parquet_check.go into round_trip.parquet.txt beside it. Run the
+// test with -update to rewrite it, then check it again with the reader.
+const parquetGolden = "test_data/parquet/round_trip.parquet"
+
+// updateGolden rewrites the golden files from what the tests write.
+var updateGolden = flag.Bool("update", false, "rewrite the golden files")
+
+// TestParquetRoundTrip decodes a file of every type, over more than one row
+// group, checking it matches the golden file along with its schema and values.
+func TestParquetRoundTrip(t *testing.T) {
+	defer func(rows int) { parquetRowGroup = rows }(parquetRowGroup)
+	parquetRowGroup = 2
+
+	names := []string{"dump_time", "local_time", "group_id", "client_count", "ratio", "rate", "up", "group_name", "extras"}
+	types := []string{"TIMESTAMPTZ", "TIMESTAMP", "INT8", "INT4", "FLOAT4", "NUMERIC", "BOOL", "TEXT", "JSONB"}
+	rows := [][]interface{}{
+		{time.Date(2014, 10, 31, 11, 0, 0, 0, tz), time.Date(2014, 10, 31, 11, 0, 0, 0, time.UTC), int64(152), int64(24), 0.5, []byte("1.25"), true, "Lerner 3", []byte(`{"firmware": "8.2"}`)},
+		{time.Date(2014, 10, 31, 11, 15, 0, 0, tz), nil, int64(131), nil, nil, nil, false, "Butler Library 3", nil},
+		{nil, time.Date(2014, 10, 31, 11, 30, 0, 0, time.UTC), nil, int64(-3), 2.0, []byte("7"), nil, nil, []byte("[]")},
+	}
+	expected := [][]interface{}{
+		{int64(1414767600000000), int64(1414768500000000), nil},
+		{int64(1414753200000000), nil, int64(1414755000000000)},
+		{int64(152), int64(131), nil},
+		{int64(24), nil, int64(-3)},
+		{0.5, nil, 2.0},
+		{1.25, nil, 7.0},
+		{true, false, nil},
+		{"Lerner 3", "Butler Library 3", nil},
+		{`{"firmware": "8.2"}`, nil, "[]"},
+	}
+
+	var buf bytes.Buffer
+	p, err := newParquetWriter(&buf, names, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err = p.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateGolden {
+		if err = ioutil.WriteFile(parquetGolden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := ioutil.ReadFile(parquetGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Errorf("Expected the file to match %s, found\n% x", parquetGolden, buf.Bytes())
+	}
+
+	meta, columns := readParquet(t, buf.Bytes())
+	if meta[3] != int64(len(rows)) || len(meta[4].([]interface{})) != 2 {
+		t.Errorf("Expected %d rows in 2 row groups, found %v in %d", len(rows), meta[3], len(meta[4].([]interface{})))
+	}
+
+	schema := meta[2].([]interface{})
+	if root := schema[0].(map[int16]interface{}); root[4] != "schema" || root[5] != int64(len(names)) {
+		t.Errorf("Expected a root with %d children, found %v", len(names), root)
+	}
+	physical := []int64{parquetInt64, parquetInt64, parquetInt64, parquetInt32, parquetFloat, parquetDouble, parquetBoolean, parquetByteArray, parquetByteArray}
+	converted := []interface{}{int64(parquetTimestampMicros), nil, nil, nil, nil, nil, nil, int64(parquetUTF8), int64(parquetJSON)}
+	for i, element := range schema[1:] {
+		fields := element.(map[int16]interface{})
+		if fields[4] != names[i] || fields[1] != physical[i] || fields[3] != int64(1) || fields[6] != converted[i] {
+			t.Errorf("Expected %s to be an optional %d converted to %v, found %v", names[i], physical[i], converted[i], fields)
+		}
+	}
+	for i, utc := range []bool{true, false} {
+		logical, _ := schema[i+1].(map[int16]interface{})[10].(map[int16]interface{})
+		timestamp, _ := logical[parquetTimestamp].(map[int16]interface{})
+		if timestamp[1] != utc || timestamp[2] == nil {
+			t.Errorf("Expected %s to be a timestamp in micros adjusted to UTC: %t, found %v", names[i], utc, logical)
+		}
+	}
+
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("Expected the values\n%v\nfound\n%v", expected, columns)
+	}
+}
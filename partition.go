@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// partitionsAhead is how many months of `density_data` partitions are created ahead
+// of each dump, configured by the command line flags.
+var partitionsAhead = 2
+
+// partitionFormat names the monthly partitions of `density_data`, e.g.
+// `density_data_2014_10`.
+const partitionFormat = "density_data_2006_01"
+
+// partitionMonth is the start of the month holding the time. The partitions are by
+// UTC month so they're always the same length.
+func partitionMonth(tm time.Time) time.Time {
+	tm = tm.UTC()
+	return time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionName is the name of the partition holding the month.
+func partitionName(month time.Time) string {
+	return month.UTC().Format(partitionFormat)
+}
+
+// partitionMonths are the months that should have a partition for a dump at the
+// time, its own and the ones ahead of it.
+func partitionMonths(dumpTime time.Time, ahead int) []time.Time {
+	month := partitionMonth(dumpTime)
+	months := make([]time.Time, 0, ahead+1)
+	for i := 0; i <= ahead; i++ {
+		months = append(months, month.AddDate(0, i, 0))
+	}
+	return months
+}
+
+// ensurePartitions creates any missing partitions of `density_data` for a dump at
+// the time, as part of the transaction inserting it.
+func ensurePartitions(txn *sql.Tx, dumpTime time.Time) error {
+	for _, month := range partitionMonths(dumpTime, partitionsAhead) {
+		if err := createPartition(txn, month); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPartition creates the partition for the month unless it already exists.
+//
+// Rows for the month may already be in `density_data_default`, which would stop the
+// partition being attached, so they're moved into the new partition first.
+func createPartition(txn *sql.Tx, month time.Time) error {
+	name := partitionName(month)
+
+	var exists bool
+	if err := txn.QueryRow("SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		return fmt.Errorf("Failed to look for partition, %s => %s", name, err.Error())
+	}
+	if exists {
+		return nil
+	}
+
+	start, end := month, month.AddDate(0, 1, 0)
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{fmt.Sprintf("CREATE TABLE %s (LIKE density_data INCLUDING DEFAULTS INCLUDING CONSTRAINTS)", name), nil},
+		{fmt.Sprintf(`INSERT INTO %s
+			SELECT * FROM density_data_default WHERE dump_time >= $1 AND dump_time < $2`, name),
+			[]interface{}{start, end}},
+		{"DELETE FROM density_data_default WHERE dump_time >= $1 AND dump_time < $2",
+			[]interface{}{start, end}},
+		{fmt.Sprintf("ALTER TABLE density_data ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')",
+			name, start.Format(time.RFC3339), end.Format(time.RFC3339)), nil},
+	} {
+		if _, err := txn.Exec(stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("Failed to create partition, %s => %s", name, err.Error())
+		}
+	}
+
+	log.Printf("Created partition %s", name)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var partitionTests = []struct {
+	dumpTime time.Time
+	names    []string
+}{
+	{time.Date(2014, 10, 31, 15, 15, 0, 0, tz), []string{"density_data_2014_10", "density_data_2014_11", "density_data_2014_12"}},
+	// late on the last day of the month in NY is already the next month in UTC
+	{time.Date(2014, 11, 30, 21, 0, 0, 0, tz), []string{"density_data_2014_12", "density_data_2015_01", "density_data_2015_02"}},
+	{time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC), []string{"density_data_2015_01", "density_data_2015_02", "density_data_2015_03"}},
+}
+
+// TestPartitionMonths checks the partitions needed for a dump and those ahead of it.
+func TestPartitionMonths(t *testing.T) {
+	for _, tt := range partitionTests {
+		months := partitionMonths(tt.dumpTime, 2)
+		if len(months) != len(tt.names) {
+			t.Errorf("Expected %d partitions for %s, found %d", len(tt.names), tt.dumpTime, len(months))
+			continue
+		}
+		for i, month := range months {
+			if name := partitionName(month); name != tt.names[i] {
+				t.Errorf("Expected partition %s for %s, found %s", tt.names[i], tt.dumpTime, name)
+			}
+			if month.Day() != 1 || month.Hour() != 0 || month.Location() != time.UTC {
+				t.Errorf("Expected the start of a UTC month, found %s", month)
+			}
+		}
+	}
+}
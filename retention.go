@@ -0,0 +1,374 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+func init() {
+	commands["retention"] = command{
+		description: "downsample and remove data older than the retention rules, -dry-run reports what would be removed",
+		run:         retentionCommand,
+	}
+}
+
+// retention rules, in months, configured by the command line flags. Zero keeps
+// the data forever.
+var (
+	keepRawMonths    = 0
+	keepHourlyMonths = 0
+)
+
+// retentionInterval is how often the retention rules are enforced while watching.
+var retentionInterval = 24 * time.Hour
+
+// rawTables are the tables of 15-minute rows removed by the raw retention rule.
+// The access points are removed outright, the rest are downsampled by the rollups.
+var rawTables = []string{"ap_density", "client_breakdown", "interpolated_data", "density_data"}
+
+// rollup downsamples one of the raw tables into its hourly table.
+type rollup struct {
+	// Table is the hourly table the raw rows are rolled up into.
+	Table string
+	// Insert rolls up the raw rows before the cutoff, $1, into any hours not yet
+	// in the hourly table.
+	Insert string
+	// Missing counts the hours before the cutoff with raw rows but no rollup.
+	Missing string
+}
+
+// rollups are the hourly tables kept in place of the raw rows, in the order they're
+// downsampled.
+var rollups = []rollup{
+	{
+		Table: "hourly_data",
+		Insert: `INSERT INTO hourly_data
+			SELECT
+				date_trunc('hour', dump_time), group_id, group_name, parent_id, parent_name, floor, wing, zone,
+				COUNT(client_count), AVG(client_count), MAX(client_count), MIN(client_count),
+				AVG(estimated_occupancy), AVG(percent_full), MAX(percent_full)
+			FROM density_data d
+			WHERE dump_time < $1 AND NOT excluded AND NOT EXISTS (
+				SELECT 1 FROM hourly_data h
+				WHERE h.hour = date_trunc('hour', d.dump_time) AND h.group_id = d.group_id
+			)
+			GROUP BY group_id, group_name, parent_id, parent_name, floor, wing, zone, date_trunc('hour', dump_time)`,
+		Missing: `SELECT COUNT(*) FROM (
+				SELECT DISTINCT date_trunc('hour', dump_time) AS hour, group_id
+				FROM density_data
+				WHERE dump_time < $1 AND NOT excluded
+			) raw
+			WHERE NOT EXISTS (
+				SELECT 1 FROM hourly_data h WHERE h.hour = raw.hour AND h.group_id = raw.group_id
+			)`,
+	},
+	{
+		Table: "hourly_breakdown",
+		Insert: `INSERT INTO hourly_breakdown
+			SELECT
+				date_trunc('hour', b.dump_time), b.group_id, d.group_name, d.parent_id, d.parent_name,
+				b.dimension, b.label,
+				COUNT(b.client_count), AVG(b.client_count), MAX(b.client_count), MIN(b.client_count)
+			FROM client_breakdown b JOIN density_data d USING (dump_time, group_id)
+			WHERE b.dump_time < $1 AND NOT d.excluded AND NOT EXISTS (
+				SELECT 1 FROM hourly_breakdown h
+				WHERE h.hour = date_trunc('hour', b.dump_time) AND h.group_id = b.group_id
+					AND h.dimension = b.dimension AND h.label = b.label
+			)
+			GROUP BY b.group_id, d.group_name, d.parent_id, d.parent_name, b.dimension, b.label,
+				date_trunc('hour', b.dump_time)`,
+		Missing: `SELECT COUNT(*) FROM (
+				SELECT DISTINCT date_trunc('hour', b.dump_time) AS hour, b.group_id, b.dimension, b.label
+				FROM client_breakdown b JOIN density_data d USING (dump_time, group_id)
+				WHERE b.dump_time < $1 AND NOT d.excluded
+			) raw
+			WHERE NOT EXISTS (
+				SELECT 1 FROM hourly_breakdown h
+				WHERE h.hour = raw.hour AND h.group_id = raw.group_id
+					AND h.dimension = raw.dimension AND h.label = raw.label
+			)`,
+	},
+	{
+		Table: "hourly_interpolated",
+		Insert: `INSERT INTO hourly_interpolated
+			SELECT
+				date_trunc('hour', dump_time), group_id, group_name, parent_id, parent_name, floor, wing, zone,
+				COUNT(client_count), AVG(client_count), MAX(client_count), MIN(client_count),
+				AVG(estimated_occupancy), AVG(percent_full), MAX(percent_full)
+			FROM interpolated_data i
+			WHERE dump_time < $1 AND NOT EXISTS (
+				SELECT 1 FROM hourly_interpolated h
+				WHERE h.hour = date_trunc('hour', i.dump_time) AND h.group_id = i.group_id
+			)
+			GROUP BY group_id, group_name, parent_id, parent_name, floor, wing, zone, date_trunc('hour', dump_time)`,
+		Missing: `SELECT COUNT(*) FROM (
+				SELECT DISTINCT date_trunc('hour', dump_time) AS hour, group_id
+				FROM interpolated_data
+				WHERE dump_time < $1
+			) raw
+			WHERE NOT EXISTS (
+				SELECT 1 FROM hourly_interpolated h WHERE h.hour = raw.hour AND h.group_id = raw.group_id
+			)`,
+	},
+}
+
+// retentionReport is what the retention rules removed, or would remove on a dry run.
+type retentionReport struct {
+	DryRun       bool
+	RawCutoff    time.Time
+	HourlyCutoff time.Time
+	Downsampled  map[string]int64
+	Partitions   []string
+	Rows         map[string]int64
+}
+
+// String describes the report, a line per table.
+func (report retentionReport) String() string {
+	verb := "removed"
+	if report.DryRun {
+		verb = "would be removed"
+	}
+
+	var lines string
+	if !report.RawCutoff.IsZero() {
+		lines += fmt.Sprintf("raw data before %s:\n", report.RawCutoff.Format("2006-01-02"))
+		for _, r := range rollups {
+			lines += fmt.Sprintf("  %s\t%d hours downsampled\n", r.Table, report.Downsampled[r.Table])
+		}
+		for _, p := range report.Partitions {
+			lines += fmt.Sprintf("  %s\tpartition %s\n", p, verb)
+		}
+		for _, table := range rawTables {
+			lines += fmt.Sprintf("  %s\t%d rows %s\n", table, report.Rows[table], verb)
+		}
+	}
+	if !report.HourlyCutoff.IsZero() {
+		lines += fmt.Sprintf("hourly data before %s:\n", report.HourlyCutoff.Format("2006-01-02"))
+		for _, r := range rollups {
+			lines += fmt.Sprintf("  %s\t%d rows %s\n", r.Table, report.Rows[r.Table], verb)
+		}
+	}
+	if lines == "" {
+		lines = "no retention rules, everything is kept\n"
+	}
+	return lines
+}
+
+// validRetention checks the rules, the hourly rollups have to outlast the raw rows
+// they replace.
+func validRetention(raw, hourly int) bool {
+	if raw < 0 || hourly < 0 {
+		return false
+	}
+	return hourly == 0 || (raw > 0 && hourly >= raw)
+}
+
+// retentionCutoff is the start of the UTC month, the given number of months back,
+// before which data is removed. Cutting off on a month keeps the partitions whole.
+func retentionCutoff(now time.Time, months int) time.Time {
+	if months <= 0 {
+		return time.Time{}
+	}
+	return partitionMonth(now).AddDate(0, -months, 0)
+}
+
+// expiredPartitions are the partitions, by name, holding only data before the cutoff.
+func expiredPartitions(names []string, cutoff time.Time) []string {
+	var expired []string
+	for _, name := range names {
+		month, err := time.Parse(partitionFormat, name)
+		if err != nil {
+			continue // the default partition
+		}
+		if !month.AddDate(0, 1, 0).After(cutoff) {
+			expired = append(expired, name)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// enforceRetention downsamples and removes everything older than the retention
+// rules in a single transaction. A dry run only counts what would be removed, and
+// rolls back the downsampling.
+//
+// The raw rows are rolled up into the hourly tables first, and nothing is removed
+// unless every hour of them has a rollup.
+func enforceRetention(db *sql.DB, now time.Time, dryRun bool) (retentionReport, error) {
+	report := retentionReport{
+		DryRun:       dryRun,
+		RawCutoff:    retentionCutoff(now, keepRawMonths),
+		HourlyCutoff: retentionCutoff(now, keepHourlyMonths),
+		Downsampled:  make(map[string]int64),
+		Rows:         make(map[string]int64),
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return report, fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+
+	if !report.RawCutoff.IsZero() {
+		err = removeRaw(txn, &report)
+	}
+	if err == nil && !report.HourlyCutoff.IsZero() {
+		err = removeHourly(txn, &report)
+	}
+	if err != nil || dryRun {
+		txn.Rollback()
+		return report, err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return report, fmt.Errorf("Failed to commit txn => %s", err.Error())
+	}
+	return report, nil
+}
+
+// removeRaw downsamples the raw rows before the report's cutoff, checks
+// every hour has a rollup, then drops the expired partitions and removes whatever
+// is left before the cutoff. A dry run stops short of removing anything.
+func removeRaw(txn *sql.Tx, report *retentionReport) error {
+	cutoff := report.RawCutoff
+
+	for _, r := range rollups {
+		result, err := txn.Exec(r.Insert, cutoff)
+		if err != nil {
+			return fmt.Errorf("Failed to downsample into %s => %s", r.Table, err.Error())
+		}
+		report.Downsampled[r.Table], _ = result.RowsAffected()
+
+		var missing int
+		if err = txn.QueryRow(r.Missing, cutoff).Scan(&missing); err != nil {
+			return fmt.Errorf("Failed to check the rollups in %s => %s", r.Table, err.Error())
+		}
+		if missing > 0 {
+			return fmt.Errorf("%d hours of raw data have no rollup in %s, nothing removed", missing, r.Table)
+		}
+	}
+
+	for _, table := range rawTables {
+		var count int64
+		err := txn.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE dump_time < $1", table), cutoff).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("Failed to count %s => %s", table, err.Error())
+		}
+		report.Rows[table] = count
+	}
+
+	partitions, err := loadPartitions(txn)
+	if err != nil {
+		return err
+	}
+	report.Partitions = expiredPartitions(partitions, cutoff)
+	if report.DryRun {
+		return nil
+	}
+
+	for _, p := range report.Partitions {
+		if _, err = txn.Exec(fmt.Sprintf("DROP TABLE %s", p)); err != nil {
+			return fmt.Errorf("Failed to drop partition, %s => %s", p, err.Error())
+		}
+	}
+
+	for _, table := range rawTables {
+		if _, err = txn.Exec(fmt.Sprintf("DELETE FROM %s WHERE dump_time < $1", table), cutoff); err != nil {
+			return fmt.Errorf("Failed to remove %s => %s", table, err.Error())
+		}
+	}
+	return nil
+}
+
+// removeHourly removes the hourly rollups before the report's cutoff.
+func removeHourly(txn *sql.Tx, report *retentionReport) error {
+	for _, r := range rollups {
+		var count int64
+		err := txn.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE hour < $1", r.Table), report.HourlyCutoff).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("Failed to count %s => %s", r.Table, err.Error())
+		}
+		report.Rows[r.Table] = count
+		if report.DryRun {
+			continue
+		}
+
+		if _, err = txn.Exec(fmt.Sprintf("DELETE FROM %s WHERE hour < $1", r.Table), report.HourlyCutoff); err != nil {
+			return fmt.Errorf("Failed to remove %s => %s", r.Table, err.Error())
+		}
+	}
+	return nil
+}
+
+// checkDownsampled refuses a dump in an hour the retention rules have already
+// downsampled, its raw rows would be counted again alongside the rollup.
+func checkDownsampled(txn *sql.Tx, dumpTime time.Time) error {
+	var downsampled bool
+	err := txn.QueryRow(`SELECT EXISTS (
+			SELECT 1 FROM hourly_data WHERE hour = date_trunc('hour', $1::timestamp with time zone)
+		)`, dumpTime).Scan(&downsampled)
+	if err != nil {
+		return fmt.Errorf("Failed to check the hourly rollups => %s", err.Error())
+	}
+	if downsampled {
+		return fmt.Errorf("%s has already been downsampled, the dump is refused", dumpTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// loadPartitions lists the names of the partitions of `density_data`.
+func loadPartitions(txn *sql.Tx) ([]string, error) {
+	rows, err := txn.Query(`SELECT c.relname
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'density_data'::regclass`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load partitions => %s", err.Error())
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("Failed to scan partition => %s", err.Error())
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// runRetention enforces the retention rules and refreshes the views, logging the
+// report. It's run on a schedule while watching.
+func runRetention(db *sql.DB) error {
+	report, err := enforceRetention(db, time.Now(), false)
+	if err != nil {
+		return err
+	}
+	log.Printf("Retention rules enforced\n%s", report)
+	return updateViews(db)
+}
+
+// retentionCommand enforces the retention rules once, or reports what they'd remove.
+func retentionCommand(args []string) error {
+	var (
+		flags  = newFlagSet("retention")
+		dryRun = flags.Bool("dry-run", false, "report what would be removed without removing anything")
+	)
+	flags.Parse(args)
+
+	db := dbConnect()
+	defer db.Close()
+
+	report, err := enforceRetention(db, time.Now(), *dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+
+	if !*dryRun {
+		return updateViews(db)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var retentionRuleTests = []struct {
+	raw, hourly int
+	valid       bool
+}{
+	{0, 0, true},
+	{18, 0, true},
+	{18, 36, true},
+	{18, 18, true},
+	{18, 12, false},
+	{0, 12, false},
+	{-1, 0, false},
+}
+
+// TestValidRetention checks that the hourly rollups must outlast the raw rows.
+func TestValidRetention(t *testing.T) {
+	for _, tt := range retentionRuleTests {
+		if valid := validRetention(tt.raw, tt.hourly); valid != tt.valid {
+			t.Errorf("Expected raw %d, hourly %d to be valid: %t, found %t", tt.raw, tt.hourly, tt.valid, valid)
+		}
+	}
+}
+
+// TestRetentionCutoff checks that the cutoff falls on the start of a UTC month.
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Date(2016, 4, 15, 12, 0, 0, 0, tz)
+	if cutoff := retentionCutoff(now, 18); !cutoff.Equal(time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 18 months back to cut off at 2014-10-01, found %s", cutoff)
+	}
+	if cutoff := retentionCutoff(now, 0); !cutoff.IsZero() {
+		t.Errorf("Expected no cutoff when keeping forever, found %s", cutoff)
+	}
+}
+
+// TestExpiredPartitions checks that only partitions wholly before the cutoff expire.
+func TestExpiredPartitions(t *testing.T) {
+	names := []string{"density_data_2014_10", "density_data_default", "density_data_2014_09", "density_data_2014_08"}
+	cutoff := time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	expected := []string{"density_data_2014_08", "density_data_2014_09"}
+	if expired := expiredPartitions(names, cutoff); !reflect.DeepEqual(expired, expected) {
+		t.Errorf("Expected %v to expire, found %v", expected, expired)
+	}
+}
+
+// TestRetentionReport checks that a dry run says what would be removed.
+func TestRetentionReport(t *testing.T) {
+	report := retentionReport{
+		DryRun:      true,
+		RawCutoff:   time.Date(2014, 10, 1, 0, 0, 0, 0, time.UTC),
+		Downsampled: map[string]int64{"hourly_data": 24, "hourly_breakdown": 72},
+		Partitions:  []string{"density_data_2014_09"},
+		Rows:        map[string]int64{"density_data": 96},
+	}
+	s := report.String()
+	for _, line := range []string{
+		"hourly_data\t24 hours downsampled",
+		"hourly_breakdown\t72 hours downsampled",
+		"hourly_interpolated\t0 hours downsampled",
+		"density_data_2014_09\tpartition would be removed",
+		"density_data\t96 rows would be removed",
+	} {
+		if !strings.Contains(s, line) {
+			t.Errorf("Expected the report to include %q, found %s", line, s)
+		}
+	}
+	if strings.Contains(s, "hourly data before") {
+		t.Errorf("Expected no hourly rule in the report, found %s", s)
+	}
+}
+
+// TestRollups checks that every raw table but the access points is downsampled,
+// into a table added by the migrations.
+func TestRollups(t *testing.T) {
+	var schema string
+	for _, m := range migrations {
+		schema += m.Up
+	}
+
+	raw := map[string]bool{}
+	for _, r := range rollups {
+		if !strings.Contains(schema, "CREATE TABLE "+r.Table+" (") {
+			t.Errorf("Expected a migration to create %s", r.Table)
+		}
+		if !strings.HasPrefix(strings.TrimSpace(r.Insert), "INSERT INTO "+r.Table) {
+			t.Errorf("Expected the rollup to insert into %s, found %s", r.Table, r.Insert)
+		}
+		for _, table := range rawTables {
+			if strings.Contains(r.Insert, "FROM "+table) {
+				raw[table] = true
+			}
+		}
+	}
+
+	for _, table := range rawTables {
+		if table != "ap_density" && !raw[table] {
+			t.Errorf("Expected %s to be downsampled", table)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Sink stores the data ingested from each dump.
+type Sink interface {
+	// Write stores a dump's dataset.
+	Write(data dataset) error
+	// Refresh brings the aggregates of the stored data up to date.
+	Refresh() error
+	// Health reports whether the sink can be written to.
+	Health() error
+	// Close releases the sink's connections.
+	Close() error
+}
+
+// Archiver is a Sink that keeps the original contents of each dump.
+type Archiver interface {
+	Archive(src string, dumpTime time.Time, filename string, contents []byte) error
+}
+
+// FileArchiver is an Archiver that can keep a dump straight from its file, for
+// dumps too large to read into memory.
+type FileArchiver interface {
+	ArchiveFile(src string, dumpTime time.Time, filename string) error
+}
+
+// Checker is a Sink that keeps enough history to check each dump against the ones
+// before it, and the lookups used to annotate it.
+type Checker interface {
+	// LoadLookups refreshes the lookups used while ingesting.
+	LoadLookups()
+	// CheckAnomalies finds the anomalies in a dataset before it's written.
+	CheckAnomalies(data dataset, dumpTime time.Time) []anomaly
+	// Record notes a dump once it's written, along with whatever was found in it.
+	Record(filename string, dumpTime time.Time, summary ingestSummary)
+}
+
+// RejectWriter is a Sink that keeps the invalid fields of each dump.
+type RejectWriter interface {
+	WriteRejects(src, filename string, rejects []rejectedRecord) error
+}
+
+// Retainer is a Sink that can enforce the retention rules on the data it stores.
+type Retainer interface {
+	// Retain downsamples and removes the data older than the retention rules.
+	Retain() error
+}
+
+// StreamWriter is a Sink that can write a dump straight from its file.
+type StreamWriter interface {
+	// WriteStream returns errNotStreamable if the file's format can't be streamed.
+	WriteStream(filename string, dumpTime time.Time) (ingestSummary, error)
+}
+
+// names of the sinks, set by the `-sink` flag
+const (
+	sinkPostgres = "postgres"
+	sinkSQLite   = "sqlite"
+	sinkMemory   = "memory"
+)
+
+// sinkName is configured by the command line flags. SQLite may be given a file as
+// a DSN, e.g. `sqlite:density.db`.
+var sinkName = sinkPostgres
+
+// parseSinkName splits the name of a sink from its DSN, if given one.
+func parseSinkName(name string) (string, string) {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// validSinkName checks that the sink is one that's handled.
+func validSinkName(name string) bool {
+	switch kind, dsn := parseSinkName(name); kind {
+	case sinkPostgres, sinkMemory:
+		return dsn == ""
+	case sinkSQLite:
+		return true
+	}
+	return false
+}
+
+// openSink connects to the sink named by `sinkName`.
+func openSink() Sink {
+	kind, dsn := parseSinkName(sinkName)
+	switch kind {
+	case sinkMemory:
+		return &memorySink{}
+	case sinkSQLite:
+		if dsn == "" {
+			dsn = sqlitePath
+		}
+		sink, err := openSQLite(dsn)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err.Error())
+		}
+		return sink
+	default:
+		return &postgresSink{db: dbConnect()}
+	}
+}
+
+// postgresSink stores everything in Postgres, with the density data bulk inserted
+// by COPY and rolled up in materialized views. The dumps recorded since the last
+// refresh are pending notification.
+type postgresSink struct {
+	db      *sql.DB
+	pending []dumpNotification
+}
+
+// Write implements Sink.
+func (s *postgresSink) Write(data dataset) error {
+	return data.insert(s.db)
+}
+
+// Refresh implements Sink, notifying the `notifyChannel` of each dump recorded
+// since the last refresh once the views are up to date.
+func (s *postgresSink) Refresh() error {
+	if err := updateViews(s.db); err != nil {
+		return err
+	}
+
+	for i := range s.pending {
+		s.pending[i].Views = materializedViews
+	}
+	err := notifyDumps(s.db, s.pending)
+	s.pending = nil
+	return err
+}
+
+// Health implements Sink, failing if the schema is behind the migrations.
+func (s *postgresSink) Health() error {
+	if err := s.db.Ping(); err != nil {
+		return fmt.Errorf("Failed to reach Postgres => %s", err.Error())
+	}
+	version, err := schemaVersion(s.db)
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(version)
+}
+
+// Close implements Sink.
+func (s *postgresSink) Close() error {
+	return s.db.Close()
+}
+
+// Archive implements Archiver.
+func (s *postgresSink) Archive(src string, dumpTime time.Time, filename string, contents []byte) error {
+	return archiveDump(s.db, src, dumpTime, filename, contents)
+}
+
+// ArchiveFile implements FileArchiver.
+func (s *postgresSink) ArchiveFile(src string, dumpTime time.Time, filename string) error {
+	return archiveFile(s.db, src, dumpTime, filename)
+}
+
+// LoadLookups implements Checker.
+func (s *postgresSink) LoadLookups() {
+	loadLookups(s.db)
+}
+
+// CheckAnomalies implements Checker.
+func (s *postgresSink) CheckAnomalies(data dataset, dumpTime time.Time) []anomaly {
+	return data.checkAnomalies(s.db, dumpTime)
+}
+
+// Record implements Checker. The dump is recorded, any gaps around it are filled and
+// it's checked against the previous dumps, then it's left pending notification.
+func (s *postgresSink) Record(filename string, dumpTime time.Time, summary ingestSummary) {
+	var err error
+	if err = recordDump(s.db, source, dumpTime, filename, summary); err != nil {
+		log.Printf("ERROR: Failed to record dump of, %s => %s", filename, err.Error())
+	} else if gaps, err := updateGaps(s.db, source, dumpTime); err != nil {
+		log.Printf("ERROR: Failed to update gaps around, %s => %s", filename, err.Error())
+	} else if err = fillGaps(s.db, dumpTime, gaps); err != nil {
+		log.Printf("ERROR: Failed to fill gaps around, %s => %s", filename, err.Error())
+	}
+
+	if err = insertAnomalies(s.db, summary.Anomalies); err != nil {
+		log.Printf("ERROR: Failed to insert anomalies from, %s => %s", filename, err.Error())
+	}
+
+	// streamed dumps don't keep their groups, they're loaded back once written
+	var loadErr error
+	if summary.Groups == nil {
+		summary.Groups, loadErr = loadDumpGroups(s.db, dumpTime)
+	}
+	if loadErr != nil {
+		log.Printf("ERROR: Failed to load groups of, %s => %s", filename, loadErr.Error())
+	} else if err = checkCoverage(s.db, dumpTime, summary.Groups); err != nil {
+		log.Printf("ERROR: Failed to check coverage of, %s => %s", filename, err.Error())
+	}
+
+	if err = recordExtraKeys(s.db, dumpTime, summary.ExtraKeys); err != nil {
+		log.Printf("ERROR: Failed to record extra keys from, %s => %s", filename, err.Error())
+	}
+
+	if notifyChannel != "" {
+		s.pending = append(s.pending, newDumpNotification(dumpTime, summary, nil))
+	}
+}
+
+// WriteRejects implements RejectWriter.
+func (s *postgresSink) WriteRejects(src, filename string, rejects []rejectedRecord) error {
+	return insertRejects(s.db, src, filename, rejects)
+}
+
+// WriteStream implements StreamWriter.
+func (s *postgresSink) WriteStream(filename string, dumpTime time.Time) (ingestSummary, error) {
+	return streamInsert(s.db, filename, dumpTime)
+}
+
+// Retain implements Retainer.
+func (s *postgresSink) Retain() error {
+	return runRetention(s.db)
+}
+
+// memorySink keeps the data in memory, for trying out the parsers locally and tests.
+type memorySink struct {
+	data      dataset
+	refreshes int
+}
+
+// Write implements Sink.
+func (s *memorySink) Write(data dataset) error {
+	s.data = append(s.data, data...)
+	log.Printf("Kept %d groups in memory, %d in total", len(data), len(s.data))
+	return nil
+}
+
+// Refresh implements Sink.
+func (s *memorySink) Refresh() error {
+	s.refreshes++
+	return nil
+}
+
+// Health implements Sink.
+func (s *memorySink) Health() error {
+	return nil
+}
+
+// Close implements Sink.
+func (s *memorySink) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var expectedDumpTime = time.Date(2014, time.October, 31, 15, 0, 0, 0, tz)
+
+// TestHandleFileMemory checks that a dump can be ingested without Postgres.
+func TestHandleFileMemory(t *testing.T) {
+	sink := &memorySink{}
+	handleFile("test_data/2014-10-31-15-00.json", sink)
+	refresh(sink)
+
+	if len(sink.data) == 0 {
+		t.Fatal("Expected groups to be written to the sink")
+	}
+	for _, d := range sink.data {
+		if !d.DumpTime.Equal(expectedDumpTime) {
+			t.Errorf("Expected every group at %s, found %s", expectedDumpTime, d.DumpTime)
+		}
+	}
+	if sink.refreshes != 1 {
+		t.Errorf("Expected 1 refresh, found %d", sink.refreshes)
+	}
+}
+
+// TestLoadAllFilesShared checks that every dump in a directory is written to the one
+// sink, refreshed once at the end.
+func TestLoadAllFilesShared(t *testing.T) {
+	sink := &memorySink{}
+	LoadAllFiles("test_data", sink)
+
+	dumps := make(map[time.Time]bool)
+	for _, d := range sink.data {
+		dumps[d.DumpTime] = true
+	}
+	if len(dumps) != 4 {
+		t.Errorf("Expected 4 dumps written to the sink, found %d", len(dumps))
+	}
+	if sink.refreshes != 1 {
+		t.Errorf("Expected 1 refresh, found %d", sink.refreshes)
+	}
+}
+
+// TestPostgresSink checks that the Postgres sink supports every optional feature.
+func TestPostgresSink(t *testing.T) {
+	var sink Sink = &postgresSink{}
+	if _, ok := sink.(Archiver); !ok {
+		t.Error("Expected the Postgres sink to archive dumps")
+	}
+	if _, ok := sink.(Checker); !ok {
+		t.Error("Expected the Postgres sink to check dumps")
+	}
+	if _, ok := sink.(RejectWriter); !ok {
+		t.Error("Expected the Postgres sink to keep rejects")
+	}
+	if _, ok := sink.(StreamWriter); !ok {
+		t.Error("Expected the Postgres sink to stream dumps")
+	}
+	if _, ok := sink.(Retainer); !ok {
+		t.Error("Expected the Postgres sink to enforce the retention rules")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema mirrors the density tables of the Postgres migrations, with the materialized views
+// as plain tables rebuilt by Refresh.
+//
+// Dump times are stored in NY time so the windows can be truncated as text.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS density_data (
+		dump_time           timestamp,
+		group_id            integer,
+		group_name          text,
+		parent_id           integer,
+		parent_name         text,
+		client_count        integer,
+		floor               integer,
+		wing                text,
+		zone                text,
+		estimated_occupancy real,
+		percent_full        real,
+		excluded            boolean DEFAULT 0,
+		extras              text,
+		PRIMARY KEY(dump_time, group_id)
+	)`,
+	`CREATE INDEX IF NOT EXISTS density_data_group_idx ON density_data (group_id, dump_time)`,
+	`CREATE TABLE IF NOT EXISTS ap_density (
+		dump_time           timestamp,
+		group_id            integer,
+		ap_name             text,
+		client_count        integer,
+		PRIMARY KEY(dump_time, group_id, ap_name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS client_breakdown (
+		dump_time           timestamp,
+		group_id            integer,
+		dimension           text,
+		label               text,
+		client_count        integer,
+		PRIMARY KEY(dump_time, group_id, dimension, label)
+	)`,
+	`CREATE TABLE IF NOT EXISTS rejects (
+		source              text,
+		dump_time           timestamp,
+		filename            text,
+		group_id            integer,
+		field               text,
+		message             text,
+		action              text,
+		record              text,
+		rejected_at         timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+}
+
+// sqliteWindows are the rollup tables, by name, along with the expression that
+// truncates a dump time to their window. Weeks start on Monday, as in Postgres.
+var sqliteWindows = []struct {
+	name     string
+	column   string
+	truncate string
+}{
+	{"hour_window", "hour", "substr(dump_time, 1, 13) || ':00:00'"},
+	{"day_window", "day", "substr(dump_time, 1, 10)"},
+	{"week_window", "week", "date(substr(dump_time, 1, 10), '-6 days', 'weekday 1')"},
+	{"month_window", "month", "substr(dump_time, 1, 7) || '-01'"},
+}
+
+// sqlitePath is the file used by the SQLite sink if its DSN doesn't name one.
+var sqlitePath = "density.db"
+
+// sqliteSink stores the density data in a single SQLite file, so the processor can
+// run without Postgres and the result can be handed to analysts.
+type sqliteSink struct {
+	db *sql.DB
+}
+
+// openSQLite opens, or creates, the SQLite file and makes sure it has the schema.
+func openSQLite(file string) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite3", file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open SQLite file, %s => %s", file, err.Error())
+	}
+	// SQLite only handles one writer at a time
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range sqliteSchema {
+		if _, err = db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Failed to create SQLite schema => %s", err.Error())
+		}
+	}
+	for _, w := range sqliteWindows {
+		for _, stmt := range []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+				%s text, group_id integer, group_name text, parent_id integer, parent_name text,
+				floor integer, wing text, zone text,
+				average_count real, max_count integer, min_count integer,
+				average_occupancy real, average_percent_full real, max_percent_full real
+			)`, w.name, w.column),
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS breakdown_%s (
+				%s text, group_id integer, group_name text, parent_id integer, parent_name text,
+				dimension text, label text, average_count real, max_count integer, min_count integer
+			)`, w.name, w.column),
+		} {
+			if _, err = db.Exec(stmt); err != nil {
+				db.Close()
+				return nil, fmt.Errorf("Failed to create SQLite schema => %s", err.Error())
+			}
+		}
+		// files created before the breakdowns had a min_count
+		if err = addSQLiteColumn(db, "breakdown_"+w.name, "min_count", "integer"); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	log.Printf("SQLite database opened at %s", file)
+	return &sqliteSink{db: db}, nil
+}
+
+// addSQLiteColumn adds a column to a table unless it already has it.
+func addSQLiteColumn(db *sql.DB, table, column, columnType string) error {
+	var found int
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM pragma_table_info('%s') WHERE name = ?", table), column).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("Failed to read columns of %s => %s", table, err.Error())
+	}
+	if found > 0 {
+		return nil
+	}
+	if _, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType)); err != nil {
+		return fmt.Errorf("Failed to add %s to %s => %s", column, table, err.Error())
+	}
+	return nil
+}
+
+// Write implements Sink. A resent dump replaces whatever was stored for its time.
+func (s *sqliteSink) Write(data dataset) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	txn, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting SQLite txn => %s", err.Error())
+	}
+	if err = data.insertSQLite(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// insertSQLite inserts the dataset, along with its access points and breakdowns, as
+// part of the given transaction.
+func (data dataset) insertSQLite(txn *sql.Tx) error {
+	for _, table := range []string{"density_data", "ap_density", "client_breakdown"} {
+		if _, err := txn.Exec(fmt.Sprintf("DELETE FROM %s WHERE dump_time = ?", table), data[0].DumpTime.In(NY)); err != nil {
+			return fmt.Errorf("Failed to remove old %s => %s", table, err.Error())
+		}
+	}
+
+	density, err := txn.Prepare(`INSERT INTO density_data
+		(dump_time, group_id, group_name, parent_id, parent_name, client_count, floor, wing, zone,
+		estimated_occupancy, percent_full, excluded, extras)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("Error prepping SQLite txn => %s", err.Error())
+	}
+	defer density.Close()
+
+	accessPoints, err := txn.Prepare(`INSERT INTO ap_density
+		(dump_time, group_id, ap_name, client_count) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("Error prepping SQLite txn => %s", err.Error())
+	}
+	defer accessPoints.Close()
+
+	breakdown, err := txn.Prepare(`INSERT INTO client_breakdown
+		(dump_time, group_id, dimension, label, client_count) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("Error prepping SQLite txn => %s", err.Error())
+	}
+	defer breakdown.Close()
+
+	for _, d := range data {
+		extras, err := d.extrasJSON()
+		if err != nil {
+			return err
+		}
+		dumpTime := d.DumpTime.In(NY)
+
+		_, err = density.Exec(
+			dumpTime,
+			d.GroupID,
+			d.GroupName,
+			d.ParentID,
+			d.ParentName,
+			sql.NullInt64{Int64: int64(d.ClientCount), Valid: !d.NullCount},
+			d.Floor,
+			d.Wing,
+			d.Zone,
+			d.EstimatedOccupancy,
+			d.PercentFull,
+			d.Excluded,
+			extras,
+		)
+		if err != nil {
+			return fmt.Errorf("Failed to insert group %d => %s", d.GroupID, err.Error())
+		}
+
+		for _, ap := range d.AccessPoints {
+			if _, err = accessPoints.Exec(dumpTime, d.GroupID, ap.Name, ap.Clients); err != nil {
+				return fmt.Errorf("Failed to insert access point %s => %s", ap.Name, err.Error())
+			}
+		}
+		for _, b := range d.Breakdown {
+			if _, err = breakdown.Exec(dumpTime, d.GroupID, b.Dimension, b.Label, b.Clients); err != nil {
+				return fmt.Errorf("Failed to insert breakdown of group %d => %s", d.GroupID, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// Refresh implements Sink, rebuilding every rollup table.
+func (s *sqliteSink) Refresh() error {
+	txn, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting SQLite txn => %s", err.Error())
+	}
+
+	for _, w := range sqliteWindows {
+		for _, stmt := range []string{
+			fmt.Sprintf("DELETE FROM %s", w.name),
+			fmt.Sprintf(`INSERT INTO %[1]s
+				SELECT %[2]s, group_id, group_name, parent_id, parent_name, floor, wing, zone,
+					AVG(client_count), MAX(client_count), MIN(client_count),
+					AVG(estimated_occupancy), AVG(percent_full), MAX(percent_full)
+				FROM density_data
+				WHERE NOT excluded
+				GROUP BY group_id, group_name, parent_id, parent_name, floor, wing, zone, %[2]s`,
+				w.name, w.truncate),
+			fmt.Sprintf("DELETE FROM breakdown_%s", w.name),
+			fmt.Sprintf(`INSERT INTO breakdown_%[1]s
+				SELECT %[2]s, d.group_id, d.group_name, d.parent_id, d.parent_name,
+					b.dimension, b.label, AVG(b.client_count), MAX(b.client_count), MIN(b.client_count)
+				FROM client_breakdown b
+				JOIN density_data d ON d.dump_time = b.dump_time AND d.group_id = b.group_id
+				WHERE NOT d.excluded
+				GROUP BY d.group_id, d.group_name, d.parent_id, d.parent_name, b.dimension, b.label, %[2]s`,
+				w.name, strings.Replace(w.truncate, "dump_time", "b.dump_time", 1)),
+		} {
+			if _, err = txn.Exec(stmt); err != nil {
+				txn.Rollback()
+				return fmt.Errorf("Failed to refresh %s => %s", w.name, err.Error())
+			}
+		}
+	}
+
+	return txn.Commit()
+}
+
+// Health implements Sink.
+func (s *sqliteSink) Health() error {
+	if err := s.db.Ping(); err != nil {
+		return fmt.Errorf("Failed to reach SQLite => %s", err.Error())
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (s *sqliteSink) Close() error {
+	return s.db.Close()
+}
+
+// WriteRejects implements RejectWriter.
+func (s *sqliteSink) WriteRejects(src, filename string, rejects []rejectedRecord) error {
+	if len(rejects) == 0 {
+		return nil
+	}
+
+	txn, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting SQLite txn => %s", err.Error())
+	}
+	for _, r := range rejects {
+		_, err = txn.Exec(`INSERT INTO rejects
+			(source, dump_time, filename, group_id, field, message, action, record)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			src, r.DumpTime.In(NY), path.Base(filename), r.GroupID, r.Field, r.Message, r.Action, r.Record)
+		if err != nil {
+			txn.Rollback()
+			return fmt.Errorf("Failed to insert reject => %s", err.Error())
+		}
+	}
+	return txn.Commit()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// TestSQLiteSink checks that dumps can be loaded and rolled up in SQLite.
+func TestSQLiteSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "density")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := openSQLite(path.Join(dir, "density.db"))
+	if err != nil {
+		t.Fatalf("Failed to open SQLite => %s", err)
+	}
+	defer sink.Close()
+	if err = sink.Health(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir("test_data")
+	for _, f := range files {
+		if !f.IsDir() {
+			handleFile(path.Join("test_data", f.Name()), sink)
+		}
+	}
+	// a resent dump replaces the first
+	handleFile("test_data/2014-10-31-15-00.json", sink)
+	if err = sink.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh => %s", err)
+	}
+
+	var dumps, groups int
+	err = sink.db.QueryRow("SELECT COUNT(DISTINCT dump_time), COUNT(DISTINCT group_id) FROM density_data").Scan(&dumps, &groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dumps != 4 || groups == 0 {
+		t.Errorf("Expected 4 dumps of every group, found %d dumps of %d groups", dumps, groups)
+	}
+
+	var hour string
+	var windows int
+	err = sink.db.QueryRow("SELECT MIN(hour), COUNT(*) FROM hour_window").Scan(&hour, &windows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hour != "2014-10-31 15:00:00" || windows != groups {
+		t.Errorf("Expected one 15:00 window per group, found %d from %s", windows, hour)
+	}
+
+	var week string
+	if err = sink.db.QueryRow("SELECT MIN(week) FROM week_window").Scan(&week); err != nil {
+		t.Fatal(err)
+	}
+	if week != "2014-10-27" {
+		t.Errorf("Expected the week to start on Monday the 27th, found %s", week)
+	}
+}
+
+// TestSQLiteBreakdownWindows checks that the breakdown rollups have the same counts
+// as the Postgres views, including in a file created before they had a min_count.
+func TestSQLiteBreakdownWindows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "density")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "density.db")
+	old, err := sql.Open("sqlite3", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE breakdown_hour_window (
+		hour text, group_id integer, group_name text, parent_id integer, parent_name text,
+		dimension text, label text, average_count real, max_count integer
+	)`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := openSQLite(file)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite => %s", err)
+	}
+	defer sink.Close()
+
+	for i, clients := range []int{4, 10} {
+		dumpTime := time.Date(2014, 10, 31, 15, 15*i, 0, 0, tz)
+		data := dataset{{DumpTime: dumpTime, GroupID: 152, GroupName: "Lerner 3", ParentID: 84, ClientCount: 12,
+			Breakdown: []clientBreakdown{{Dimension: "band", Label: "5ghz", Clients: clients}}}}
+		if err = sink.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sink.Refresh(); err != nil {
+		t.Fatalf("Failed to refresh => %s", err)
+	}
+
+	var average float64
+	var max, min int
+	err = sink.db.QueryRow(`SELECT average_count, max_count, min_count FROM breakdown_hour_window
+		WHERE group_id = 152 AND label = '5ghz'`).Scan(&average, &max, &min)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if average != 7 || max != 10 || min != 4 {
+		t.Errorf("Expected an average of 7 between 4 and 10, found %f between %d and %d", average, min, max)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// streamSize is the size, in bytes, at which a dump is streamed into the database
+// rather than read into memory. Zero never streams.
+var streamSize int64 = 64 << 20
+
+// sniffSize is how much of a streamed file is read to pick its decoder.
+const sniffSize = 64 << 10
+
+// errNotStreamable is returned when the decoder for a file can't stream it.
+var errNotStreamable = errors.New("decoder can't stream")
+
+// Streamer is a Decoder that can emit each record as it's read, so the whole dump
+// never has to be held in memory.
+type Streamer interface {
+	// Stream parses every record from the reader, annotates it with the timestamp and
+	// passes it to emit. Any error from emit stops the stream.
+	Stream(timestamp time.Time, r io.Reader, emit func(dumpFormat) error) error
+}
+
+// collect streams the contents into a dataset, letting a Streamer implement Decode.
+func collect(s Streamer, timestamp time.Time, contents []byte) (dataset, error) {
+	var data dataset
+	err := s.Stream(timestamp, bytes.NewReader(contents), func(d dumpFormat) error {
+		data = append(data, d)
+		return nil
+	})
+	if err != nil {
+		return dataset{}, err
+	}
+	return data, nil
+}
+
+// expectDelim reads the next token, failing unless it's the given delimiter.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("Error parsing bytes => %s", err.Error())
+	}
+	if token != delim {
+		return fmt.Errorf("Expected '%s', found %v", delim, token)
+	}
+	return nil
+}
+
+// ingestSummary is what's kept of a dump once it's inserted, enough for the checks
+// that run afterwards without holding on to the records.
+type ingestSummary struct {
+	Format    string
+	Version   int
+	Rows      int
+	Groups    map[int]bool
+	ExtraKeys []string
+	Anomalies []anomaly
+	Rejects   []rejectedRecord
+}
+
+// summarize the dataset, in the named format, along with the anomalies and invalid
+// fields found in it.
+func (data dataset) summarize(format string, anomalies []anomaly, rejects []rejectedRecord) ingestSummary {
+	return ingestSummary{
+		Format:    format,
+		Version:   data.formatVersion(),
+		Rows:      len(data),
+		Groups:    data.groupIDs(),
+		ExtraKeys: data.extraKeys(),
+		Anomalies: anomalies,
+		Rejects:   rejects,
+	}
+}
+
+// opener opens the contents of a dump, once for every time it's read through.
+type opener func() (io.ReadCloser, error)
+
+// fileOpener opens a dump file.
+func fileOpener(filename string) opener {
+	return func() (io.ReadCloser, error) {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open file => %s", err.Error())
+		}
+		return file, nil
+	}
+}
+
+// streamerFor picks the Streamer for a dump by sniffing its first few bytes,
+// returning the name of its format along with it.
+func streamerFor(filename string, open opener) (string, Streamer, error) {
+	r, err := open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	head := make([]byte, sniffSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, fmt.Errorf("Failed to read %s => %s", filename, err.Error())
+	}
+
+	name, decoder, err := decoderFor(filename, head[:n])
+	if err != nil {
+		return "", nil, err
+	}
+	streamer, ok := decoder.(Streamer)
+	if !ok {
+		return "", nil, errNotStreamable
+	}
+	return name, streamer, nil
+}
+
+// streamContents streams every record of a dump to emit.
+func streamContents(open opener, s Streamer, timestamp time.Time, emit func(dumpFormat) error) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.Stream(timestamp, bufio.NewReader(r), emit)
+}
+
+// copyStreamed streams every record of a dump into a bulk insert started by prepare.
+func copyStreamed(txn *sql.Tx, open opener, s Streamer, timestamp time.Time,
+	prepare func(*sql.Tx) (*sql.Stmt, error), add func(dumpFormat, *sql.Stmt) error) error {
+	stmt, err := prepare(txn)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	err = streamContents(open, s, timestamp, func(d dumpFormat) error {
+		return add(d, stmt)
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = stmt.Exec(); err != nil {
+		return fmt.Errorf("Failed to execute bulk insert => %s", err.Error())
+	}
+	return nil
+}
+
+// maxStreamSamples caps the anomalies, rejects and extra keys held in memory while
+// a dump is streamed.
+var maxStreamSamples = 1000
+
+// streamTally keeps what's needed of each record of a streamed dump in a bounded
+// amount of memory, counts along with the first anomalies and extra keys found.
+// Rejects are passed to write in batches as they're found, the first are kept to
+// explain why a dump was rejected.
+type streamTally struct {
+	summary   ingestSummary
+	anomalies int
+	rejects   int
+	sample    []rejectedRecord
+	batch     []rejectedRecord
+	extraKeys map[string]bool
+	dropped   int // extra keys past the cap
+	write     func([]rejectedRecord) error
+}
+
+// newStreamTally starts a tally of a dump in the named format.
+func newStreamTally(format string, write func([]rejectedRecord) error) *streamTally {
+	return &streamTally{
+		summary:   ingestSummary{Format: format},
+		extraKeys: make(map[string]bool),
+		write:     write,
+	}
+}
+
+// reject counts the invalid fields of a record, writing them once a batch is full.
+func (t *streamTally) reject(rejects []rejectedRecord) error {
+	t.rejects += len(rejects)
+	for _, r := range rejects {
+		if len(t.sample) < maxStreamSamples {
+			t.sample = append(t.sample, r)
+		}
+	}
+	t.batch = append(t.batch, rejects...)
+	if len(t.batch) >= maxStreamSamples {
+		return t.flush()
+	}
+	return nil
+}
+
+// flush writes the batch of rejects.
+func (t *streamTally) flush() error {
+	if len(t.batch) == 0 {
+		return nil
+	}
+	err := t.write(t.batch)
+	t.batch = t.batch[:0]
+	return err
+}
+
+// add counts a record that's written, along with its anomalies.
+func (t *streamTally) add(d dumpFormat, found []anomaly) {
+	t.summary.Rows++
+	if d.Version > t.summary.Version {
+		t.summary.Version = d.Version
+	}
+
+	t.anomalies += len(found)
+	for _, a := range found {
+		if len(t.summary.Anomalies) < maxStreamSamples {
+			t.summary.Anomalies = append(t.summary.Anomalies, a)
+		}
+	}
+	for key := range d.Extras {
+		if t.extraKeys[key] {
+			continue
+		}
+		if len(t.extraKeys) < maxStreamSamples {
+			t.extraKeys[key] = true
+		} else {
+			t.dropped++
+		}
+	}
+}
+
+// validationError describes the invalid fields of a rejected dump, nil if it has
+// none.
+func (t *streamTally) validationError() error {
+	switch {
+	case t.rejects == 0:
+		return nil
+	case t.rejects == len(t.sample):
+		return validationError(t.sample)
+	}
+	return fmt.Errorf("%d invalid fields, the first %d => %s",
+		t.rejects, len(t.sample), validationError(t.sample).messages())
+}
+
+// finish writes any rejects left and completes the summary. Its groups aren't kept,
+// they're as many as its rows.
+func (t *streamTally) finish(timestamp time.Time) (ingestSummary, error) {
+	err := t.flush()
+
+	for key := range t.extraKeys {
+		t.summary.ExtraKeys = append(t.summary.ExtraKeys, key)
+	}
+	sort.Strings(t.summary.ExtraKeys)
+	if t.dropped > 0 {
+		log.Printf("WARNING: Only recording %d of the extra keys in dump at %s", len(t.extraKeys), timestamp)
+	}
+	if t.anomalies > len(t.summary.Anomalies) {
+		log.Printf("WARNING: %d anomalies found in dump at %s, only recording the first %d",
+			t.anomalies, timestamp, len(t.summary.Anomalies))
+	} else if t.anomalies > 0 {
+		log.Printf("WARNING: %d anomalies found in dump at %s", t.anomalies, timestamp)
+	}
+	return t.summary, err
+}
+
+// streamBuildings reads the dump once to find the buildings whose every group
+// dropped to zero, which can't be known a record at a time.
+func streamBuildings(open opener, s Streamer, timestamp time.Time, previous map[int]int) (buildingCounts, error) {
+	buildings := newBuildingCounts()
+	if len(previous) == 0 {
+		return buildings, nil
+	}
+	err := streamContents(open, s, timestamp, func(d dumpFormat) error {
+		if keep, _ := d.applyPolicy(invalidPolicy); keep {
+			buildings.add(d, previous)
+		}
+		return nil
+	})
+	return buildings, err
+}
+
+// streamInsert inserts a dump straight from its file in a single transaction,
+// holding only one record, and the counts of each building, in memory at a time.
+//
+// The file is read once for the counts of each building, then the density data is
+// copied as the file is read again, with each record checked for anomalies as it
+// goes. The file is then read again for the access points and breakdowns, if it had
+// any, as only one bulk insert can run at a time. Invalid records are handled by the
+// `invalidPolicy` as they're read, and their rejects written in batches, under the
+// reject policy the file is still read to the end to find every invalid field before
+// it's rolled back.
+func streamInsert(db *sql.DB, filename string, timestamp time.Time) (ingestSummary, error) {
+	return streamDump(db, filename, fileOpener(filename), timestamp, false)
+}
+
+// streamDump inserts a dump as it's read, see streamInsert. A dump that's replaced,
+// when it's reparsed, has its previous density data and rejects removed, and keeps
+// the groups that were excluded rather than being checked for anomalies again.
+func streamDump(db *sql.DB, filename string, open opener, timestamp time.Time, replace bool) (ingestSummary, error) {
+	format, streamer, err := streamerFor(filename, open)
+	if err != nil {
+		return ingestSummary{}, err
+	}
+	tally := newStreamTally(format, func(rejects []rejectedRecord) error {
+		return insertRejects(db, source, filename, rejects)
+	})
+
+	var (
+		previous  map[int]int
+		history   map[int][]int
+		buildings = newBuildingCounts()
+		excluded  map[int]bool
+	)
+	if replace {
+		if excluded, err = loadExcluded(db, timestamp); err != nil {
+			return tally.summary, err
+		}
+		if _, err = db.Exec("DELETE FROM rejects WHERE source = $1 AND dump_time = $2", source, timestamp); err != nil {
+			return tally.summary, fmt.Errorf("Failed to remove old rejects => {%s}", err)
+		}
+	} else {
+		previous, history = loadAnomalyHistory(db, timestamp)
+		if buildings, err = streamBuildings(open, streamer, timestamp, previous); err != nil {
+			return tally.summary, err
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return tally.summary, fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+	if err = ensurePartitions(txn, timestamp); err == nil {
+		err = checkDownsampled(txn, timestamp)
+	}
+	if err != nil {
+		txn.Rollback()
+		return tally.summary, err
+	}
+	if replace {
+		if err = deleteDumpData(txn, timestamp); err != nil {
+			txn.Rollback()
+			return tally.summary, err
+		}
+	}
+
+	var hasAccessPoints, hasBreakdown bool
+	err = copyStreamed(txn, open, streamer, timestamp, prepareDensityCopy,
+		func(d dumpFormat, stmt *sql.Stmt) error {
+			keep, invalid := d.applyPolicy(invalidPolicy)
+			if err := tally.reject(invalid); err != nil {
+				log.Printf("ERROR: Failed to insert rejects from, %s => %s", filename, err.Error())
+			}
+			if !keep || (invalidPolicy == invalidReject && tally.rejects > 0) {
+				return nil
+			}
+
+			d.validateAccessPoints()
+			d.estimateOccupancy()
+			var found []anomaly
+			if replace {
+				d.Excluded = excluded[d.GroupID]
+			} else {
+				found = d.detectAnomalies(anomalyChecks, previous, history, buildings.zero(d.ParentID))
+				d.Excluded = anomalyChecks.Exclude && len(found) > 0
+			}
+
+			tally.add(d, found)
+			hasAccessPoints = hasAccessPoints || len(d.AccessPoints) > 0
+			hasBreakdown = hasBreakdown || len(d.Breakdown) > 0
+
+			return d.copyRow(stmt)
+		})
+	if err == nil && invalidPolicy == invalidReject {
+		err = tally.validationError()
+	}
+
+	if err == nil && hasAccessPoints {
+		err = copyStreamed(txn, open, streamer, timestamp, prepareAccessPointCopy,
+			func(d dumpFormat, stmt *sql.Stmt) error {
+				if keep, _ := d.applyPolicy(invalidPolicy); !keep {
+					return nil
+				}
+				d.validateAccessPoints()
+				return d.copyAccessPoints(stmt)
+			})
+	}
+	if err == nil && hasBreakdown {
+		err = copyStreamed(txn, open, streamer, timestamp, prepareBreakdownCopy,
+			func(d dumpFormat, stmt *sql.Stmt) error {
+				if keep, _ := d.applyPolicy(invalidPolicy); !keep {
+					return nil
+				}
+				return d.copyBreakdown(stmt)
+			})
+	}
+
+	summary, flushErr := tally.finish(timestamp)
+	if flushErr != nil {
+		log.Printf("ERROR: Failed to insert rejects from, %s => %s", filename, flushErr.Error())
+	}
+	if err == nil && replace {
+		err = updateDump(txn, source, timestamp, summary)
+	}
+	if err != nil {
+		txn.Rollback()
+		return summary, err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return summary, fmt.Errorf("Failed to commit txn => %s", err.Error())
+	}
+	return summary, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStream checks that streaming each format emits the same records it decodes to.
+func TestStream(t *testing.T) {
+	for _, tt := range decoderTests {
+		name, decoder, err := decoderFor(tt.filename, []byte(tt.contents))
+		if err != nil {
+			t.Fatalf("Failed to find decoder for %s => %s", tt.decoder, err)
+		}
+		streamer, ok := decoder.(Streamer)
+		if !ok {
+			t.Errorf("Expected the %s decoder to stream", name)
+			continue
+		}
+
+		var streamed dataset
+		err = streamer.Stream(time.Time{}, strings.NewReader(tt.contents), func(d dumpFormat) error {
+			streamed = append(streamed, d)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Failed to stream with %s => %s", name, err)
+			continue
+		}
+
+		decoded, _ := decoder.Decode(time.Time{}, []byte(tt.contents))
+		if len(streamed) != len(decoded) {
+			t.Errorf("Expected %d records streamed by %s, found %d", len(decoded), name, len(streamed))
+		}
+		for _, d := range streamed {
+			found := false
+			for _, e := range decoded {
+				if reflect.DeepEqual(d, e) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("No match in decoded data for %#v streamed by %s", d, name)
+			}
+		}
+	}
+}
+
+// TestStreamTruncated checks that a cut off dump fails rather than emitting a partial dataset.
+func TestStreamTruncated(t *testing.T) {
+	truncated := testingData1[:len(testingData1)/2]
+	err := (objectDecoder{}).Stream(time.Time{}, strings.NewReader(truncated), func(dumpFormat) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("Expected error for truncated dump")
+	}
+}
+
+// TestStreamerFor checks that the streamer is picked by sniffing the start of the file.
+func TestStreamerFor(t *testing.T) {
+	name, streamer, err := streamerFor("test_data/2014-10-31-15-00.json", fileOpener("test_data/2014-10-31-15-00.json"))
+	if err != nil {
+		t.Fatalf("Failed to find streamer => %s", err)
+	}
+	if name != "cuit" {
+		t.Errorf("Expected the cuit format, found %s", name)
+	}
+	if _, ok := streamer.(objectDecoder); !ok {
+		t.Errorf("Expected the object decoder, found %#v", streamer)
+	}
+
+	if _, _, err = streamerFor("test_data/vendor/airwave_ap_list.xml", fileOpener("test_data/vendor/airwave_ap_list.xml")); err != errNotStreamable {
+		t.Errorf("Expected controller export to not stream, found %v", err)
+	}
+}
+
+// TestStreamTally checks that only the first anomalies and rejects are held while
+// every reject is written in batches.
+func TestStreamTally(t *testing.T) {
+	defer func(original int) { maxStreamSamples = original }(maxStreamSamples)
+	maxStreamSamples = 2
+
+	var written [][]rejectedRecord
+	tally := newStreamTally("cuit", func(rejects []rejectedRecord) error {
+		written = append(written, append([]rejectedRecord(nil), rejects...))
+		return nil
+	})
+
+	for i := 0; i < 5; i++ {
+		tally.reject([]rejectedRecord{{Field: "client_count", Message: "not a number"}})
+		tally.add(dumpFormat{GroupID: i, Version: 2, Extras: map[string]interface{}{fmt.Sprint("key", i): true}},
+			[]anomaly{{GroupID: i, Check: checkBounds}})
+	}
+	if err := tally.validationError(); err == nil || !strings.HasPrefix(err.Error(), "5 invalid fields, the first 2") {
+		t.Errorf("Expected the count and first rejects in the error, found %v", err)
+	}
+
+	summary, err := tally.finish(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Rows != 5 || summary.Version != 2 || summary.Groups != nil {
+		t.Errorf("Expected 5 rows of version 2 without their groups, found %+v", summary)
+	}
+	if len(summary.Anomalies) != 2 || len(summary.ExtraKeys) != 2 || len(summary.Rejects) != 0 {
+		t.Errorf("Expected 2 anomalies, 2 extra keys and no rejects kept, found %d, %d and %d",
+			len(summary.Anomalies), len(summary.ExtraKeys), len(summary.Rejects))
+	}
+
+	var count int
+	for _, batch := range written {
+		if len(batch) > 2 {
+			t.Errorf("Expected batches of at most 2 rejects, found %d", len(batch))
+		}
+		count += len(batch)
+	}
+	if count != 5 {
+		t.Errorf("Expected all 5 rejects written, found %d", count)
+	}
+}
+
+// TestStreamBuildings checks that the counts of each building found by streaming
+// match those of the whole dataset.
+func TestStreamBuildings(t *testing.T) {
+	filename := "test_data/2014-10-31-15-00.json"
+	_, streamer, err := streamerFor(filename, fileOpener(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := decodeFile(filename, time.Time{}, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := make(map[int]int)
+	expected := newBuildingCounts()
+	for _, d := range data {
+		previous[d.GroupID] = d.ClientCount + 1
+	}
+	for _, d := range data {
+		expected.add(d, previous)
+	}
+
+	buildings, err := streamBuildings(fileOpener(filename), streamer, time.Time{}, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(buildings, expected) {
+		t.Errorf("Expected building counts %v, found %v", expected, buildings)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build ignore
+// +build ignore
+
+// parquet_check reads a Parquet file written by the exporter with parquet-go, a
+// maintained reader independent of it, and prints its schema, metadata and values
+// to compare with what TestParquetRoundTrip expects. It isn't vendored, so run it
+// from a module that requires github.com/parquet-go/parquet-go:
+//
+//	go run parquet_check.go round_trip.parquet > round_trip.parquet.txt
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: parquet_check file.parquet")
+	}
+	f, err := os.Open(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		log.Fatalf("Failed to open => %s", err)
+	}
+
+	meta := pf.Metadata()
+	fmt.Printf("version %d, created by %s, %d rows in %d row groups\n", meta.Version, meta.CreatedBy, meta.NumRows, len(meta.RowGroups))
+	fmt.Println(pf.Schema())
+	for _, element := range meta.Schema[1:] {
+		converted := "none"
+		if element.ConvertedType.Valid {
+			converted = fmt.Sprint(element.ConvertedType.V)
+		}
+		fmt.Printf("%s: %s %s, converted %s, logical %v\n", element.Name, element.RepetitionType.V, element.Type.V, converted, element.LogicalType)
+	}
+
+	columns := pf.Schema().Columns()
+	for i, rg := range pf.RowGroups() {
+		fmt.Printf("row group %d, %d rows\n", i, rg.NumRows())
+		rows := rg.Rows()
+		buf := make([]parquet.Row, 1)
+		for {
+			n, err := rows.ReadRows(buf)
+			if n == 1 {
+				for _, v := range buf[0] {
+					fmt.Printf("  %s=%s", columns[v.Column()][0], value(v))
+				}
+				fmt.Println()
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				log.Fatalf("Failed to read rows => %s", err)
+			}
+		}
+		rows.Close()
+	}
+}
+
+// value formats a value by its kind, null as such.
+func value(v parquet.Value) string {
+	if v.IsNull() {
+		return "null"
+	}
+	switch v.Kind() {
+	case parquet.ByteArray:
+		return fmt.Sprintf("%q", v.ByteArray())
+	default:
+		return v.String()
+	}
+}
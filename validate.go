@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// policies for records with invalid fields
+const (
+	invalidReject = "reject" // reject the whole file
+	invalidSkip   = "skip"   // skip the invalid records, keeping the rest
+	invalidNull   = "null"   // keep the records with a null count, skipping any that can't be kept
+)
+
+// actions taken on an invalid record, stored in the `action` column of `rejects`
+const (
+	actionRejected = "rejected"
+	actionSkipped  = "skipped"
+	actionNulled   = "nulled"
+)
+
+// invalidPolicy is configured by the command line flags.
+var invalidPolicy = invalidReject
+
+// nullableFields are the fields of a record that can be stored as null, or dropped,
+// under the null policy. A record with any other invalid field is skipped.
+var nullableFields = map[string]bool{
+	"client_count":  true,
+	"access_points": true,
+	"breakdown":     true,
+}
+
+// fieldError is a problem with a single field of a record.
+type fieldError struct {
+	Field   string
+	Message string
+}
+
+// rejectedRecord is an invalid field of a record along with what was done with it.
+// Record holds the original contents of the record.
+type rejectedRecord struct {
+	DumpTime time.Time
+	GroupID  sql.NullInt64
+	Field    string
+	Message  string
+	Action   string
+	Record   string
+}
+
+// String describes the invalid field.
+func (r rejectedRecord) String() string {
+	group := "unknown group"
+	if r.GroupID.Valid {
+		group = fmt.Sprintf("group %d", r.GroupID.Int64)
+	}
+	return fmt.Sprintf("%s '%s': %s", group, r.Field, r.Message)
+}
+
+// validationError lists every invalid field found in a dump.
+type validationError []rejectedRecord
+
+// Error implements the error interface.
+func (e validationError) Error() string {
+	return fmt.Sprintf("%d invalid fields => %s", len(e), e.messages())
+}
+
+// messages lists each invalid field.
+func (e validationError) messages() string {
+	messages := make([]string, len(e))
+	for i, r := range e {
+		messages[i] = r.String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validInvalidPolicy checks that the policy is one that's handled.
+func validInvalidPolicy(policy string) bool {
+	switch policy {
+	case invalidReject, invalidSkip, invalidNull:
+		return true
+	}
+	return false
+}
+
+// invalidate notes a problem with one of the record's fields.
+func (df *dumpFormat) invalidate(field string, err error) {
+	df.Invalid = append(df.Invalid, fieldError{Field: field, Message: err.Error()})
+}
+
+// keepRaw holds on to the original contents of the record if it's invalid.
+func (df *dumpFormat) keepRaw(raw string) {
+	if len(df.Invalid) > 0 {
+		df.Raw = raw
+	}
+}
+
+// nullable reports whether every invalid field of the record can be nulled.
+func (df dumpFormat) nullable() bool {
+	for _, e := range df.Invalid {
+		if !nullableFields[e.Field] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyPolicy decides what's done with an invalid record, reporting whether the
+// record is kept along with each of its invalid fields.
+//
+// Under the null policy a kept record has its invalid fields cleared, a null
+// count can't be checked against its access points so they're cleared too.
+func (df *dumpFormat) applyPolicy(policy string) (bool, []rejectedRecord) {
+	if len(df.Invalid) == 0 {
+		return true, nil
+	}
+
+	keep := policy == invalidNull && df.nullable()
+	action := actionSkipped
+	if keep {
+		action = actionNulled
+	} else if policy == invalidReject {
+		action = actionRejected
+	}
+
+	groupID := sql.NullInt64{Int64: int64(df.GroupID), Valid: true}
+	rejects := make([]rejectedRecord, len(df.Invalid))
+	for i, e := range df.Invalid {
+		if e.Field == "group_id" {
+			groupID.Valid = false
+		}
+		rejects[i] = rejectedRecord{
+			DumpTime: df.DumpTime,
+			Field:    e.Field,
+			Message:  e.Message,
+			Action:   action,
+			Record:   df.Raw,
+		}
+	}
+	for i := range rejects {
+		rejects[i].GroupID = groupID
+	}
+
+	if keep {
+		for _, e := range df.Invalid {
+			switch e.Field {
+			case "client_count":
+				df.ClientCount = 0
+				df.NullCount = true
+				df.AccessPoints = nil
+			case "access_points":
+				df.AccessPoints = nil
+			case "breakdown":
+				df.Breakdown = nil
+			}
+		}
+		df.Invalid = nil
+		df.Raw = ""
+	}
+	return keep, rejects
+}
+
+// validate applies the policy to every record of the dataset, returning the records
+// that are kept and every invalid field found.
+//
+// Under the reject policy nothing is kept if any record is invalid, and the error
+// lists every invalid field.
+func (data dataset) validate(policy string) (dataset, []rejectedRecord, error) {
+	var (
+		kept    = make(dataset, 0, len(data))
+		rejects []rejectedRecord
+	)
+	for _, d := range data {
+		keep, invalid := d.applyPolicy(policy)
+		rejects = append(rejects, invalid...)
+		if keep {
+			kept = append(kept, d)
+		}
+	}
+
+	if policy == invalidReject && len(rejects) > 0 {
+		return nil, rejects, validationError(rejects)
+	}
+	return kept, rejects, nil
+}
+
+// insertRejects records the invalid fields of a dump in the `rejects` table.
+func insertRejects(db *sql.DB, src, filename string, rejects []rejectedRecord) error {
+	if len(rejects) == 0 {
+		return nil
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Error starting PG txn => %s", err.Error())
+	}
+	if err = insertRejectsTx(txn, src, filename, rejects); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// insertRejectsTx records the invalid fields of a dump within the transaction.
+func insertRejectsTx(txn *sql.Tx, src, filename string, rejects []rejectedRecord) error {
+	if len(rejects) == 0 {
+		return nil
+	}
+
+	stmt, err := txn.Prepare(`INSERT INTO rejects
+		(source, dump_time, filename, group_id, field, message, action, record)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return fmt.Errorf("Error prepping PG txn => %s", err.Error())
+	}
+	defer stmt.Close()
+
+	name := path.Base(filename)
+	for _, r := range rejects {
+		_, err = stmt.Exec(src, r.DumpTime, name, r.GroupID, r.Field, r.Message, r.Action, r.Record)
+		if err != nil {
+			return fmt.Errorf("Failed to insert reject => %s", err.Error())
+		}
+	}
+	return nil
+}
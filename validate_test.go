@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testingInvalid = `{
+  "152" : {"name" : "Lerner 3", "client_count" : "n/a", "parent_id" : 84},
+  "131" : {"name" : "Butler Library 3", "client_count" : 328, "parent_id" : 103},
+  "abc" : {"name" : "JJ's Place", "client_count" : 90, "parent_id" : 75},
+  "130" : {"name" : 130, "client_count" : "many", "parent_id" : 103}
+}`
+
+// decodeInvalid parses testingInvalid, failing the test if the whole dump fails.
+func decodeInvalid(t *testing.T) dataset {
+	data, err := parseData(time.Time{}, []byte(testingInvalid))
+	if err != nil {
+		t.Fatalf("Failed to parse dump with invalid records => %s", err)
+	}
+	return data
+}
+
+// TestValidateReject checks that every invalid field is listed when the file is rejected.
+func TestValidateReject(t *testing.T) {
+	kept, rejects, err := decodeInvalid(t).validate(invalidReject)
+	if err == nil {
+		t.Fatal("Expected the dump to be rejected")
+	}
+	if len(kept) != 0 {
+		t.Errorf("Expected nothing kept, found %#v", kept)
+	}
+	if len(rejects) != 4 {
+		t.Fatalf("Expected 4 invalid fields, found %#v", rejects)
+	}
+	for _, r := range rejects {
+		if r.Action != actionRejected || r.Record == "" {
+			t.Errorf("Expected a rejected record with its contents, found %#v", r)
+		}
+		if r.Field == "group_id" && r.GroupID.Valid {
+			t.Errorf("Expected no group ID for an invalid group_id, found %#v", r)
+		}
+	}
+}
+
+// TestValidateSkip checks that only the valid records are kept.
+func TestValidateSkip(t *testing.T) {
+	kept, rejects, err := decodeInvalid(t).validate(invalidSkip)
+	if err != nil {
+		t.Fatalf("Failed to validate => %s", err)
+	}
+	if len(kept) != 1 || kept[0].GroupID != 131 {
+		t.Errorf("Expected only group 131 kept, found %#v", kept)
+	}
+	for _, r := range rejects {
+		if r.Action != actionSkipped {
+			t.Errorf("Expected a skipped record, found %#v", r)
+		}
+	}
+}
+
+// TestValidateNull checks that records with only an invalid count are kept with a
+// null count, while those that can't be identified are skipped.
+func TestValidateNull(t *testing.T) {
+	kept, rejects, err := decodeInvalid(t).validate(invalidNull)
+	if err != nil {
+		t.Fatalf("Failed to validate => %s", err)
+	}
+	if len(kept) != 2 {
+		t.Fatalf("Expected 2 groups kept, found %#v", kept)
+	}
+
+	for _, d := range kept {
+		switch d.GroupID {
+		case 131:
+			if d.NullCount {
+				t.Error("Expected group 131 to keep its count")
+			}
+		case 152:
+			if !d.NullCount || d.Invalid != nil || d.ParentName != "Lerner" {
+				t.Errorf("Expected group 152 with a null count, found %#v", d)
+			}
+		default:
+			t.Errorf("Unexpected group kept, %#v", d)
+		}
+	}
+
+	var nulled, skipped int
+	for _, r := range rejects {
+		switch r.Action {
+		case actionNulled:
+			nulled++
+		case actionSkipped:
+			skipped++
+		}
+	}
+	if nulled != 1 || skipped != 3 {
+		t.Errorf("Expected 1 nulled and 3 skipped fields, found %#v", rejects)
+	}
+}
+
+// TestCSVInvalid checks that invalid CSV rows are noted rather than failing the file.
+func TestCSVInvalid(t *testing.T) {
+	data, err := (csvDecoder{}).Decode(time.Time{}, []byte("group_id,name,client_count,parent_id\n152,Lerner 3,n/a,84\n131,Butler Library 3,328,103\n"))
+	if err != nil {
+		t.Fatalf("Failed to decode CSV => %s", err)
+	}
+	if len(data) != 2 || len(data[0].Invalid) != 1 || data[0].Invalid[0].Field != "client_count" {
+		t.Errorf("Expected an invalid client_count on the first row, found %#v", data)
+	}
+	if data[0].Raw != "152,Lerner 3,n/a,84" {
+		t.Errorf("Expected the row to be kept, found %s", data[0].Raw)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apGroup maps access points from a controller export to one of our groups.
+// An AP belongs to the group if its name is listed or matches the pattern.
+type apGroup struct {
+	GroupID      int      `json:"group_id"`
+	Name         string   `json:"name"`
+	ParentID     int      `json:"parent_id"`
+	AccessPoints []string `json:"access_points,omitempty"`
+	Pattern      string   `json:"pattern,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// apGroups is loaded from the file given to the `-ap-groups` flag.
+var apGroups []apGroup
+
+// maxHeaderSearch is how many rows of a CSV export are searched for the header,
+// controllers add report titles and dates above it.
+const maxHeaderSearch = 10
+
+func init() {
+	registerDecoder("airwave-csv", apCSVDecoder{nameColumn: "Device", countColumn: "Clients"}, ".csv")
+	registerDecoder("prime-csv", apCSVDecoder{nameColumn: "AP Name", countColumn: "Client Count"}, ".csv")
+	registerDecoder("airwave-xml", airwaveXMLDecoder{}, ".xml")
+	registerDecoder("prime-xml", primeXMLDecoder{}, ".xml")
+}
+
+// loadAPGroups reads the AP to group mapping from the given JSON file.
+// The file should hold an array of groups.
+func loadAPGroups(filename string) error {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to read AP groups file, %s => {%s}", filename, err)
+	}
+
+	var groups []apGroup
+	if err = json.Unmarshal(contents, &groups); err != nil {
+		return fmt.Errorf("Failed to parse AP groups file, %s => {%s}", filename, err)
+	}
+	for i, g := range groups {
+		if g.Pattern == "" {
+			continue
+		}
+		if groups[i].regex, err = regexp.Compile(g.Pattern); err != nil {
+			return fmt.Errorf("Invalid pattern for group %d, %s => {%s}", g.GroupID, g.Pattern, err)
+		}
+	}
+
+	apGroups = groups
+	return nil
+}
+
+// groupForAP finds the group an access point belongs to.
+func groupForAP(name string) (apGroup, bool) {
+	for _, g := range apGroups {
+		for _, ap := range g.AccessPoints {
+			if ap == name {
+				return g, true
+			}
+		}
+		if g.regex != nil && g.regex.MatchString(name) {
+			return g, true
+		}
+	}
+	return apGroup{}, false
+}
+
+// aggregateAPs sums the clients on each access point up to the groups they belong to,
+// keeping the counts of each access point in the group.
+// Access points without a group are logged and left out, and any access point with
+// an invalid count invalidates its group's count, to be handled by the
+// `invalidPolicy`.
+func aggregateAPs(timestamp time.Time, counts []apCount) (dataset, error) {
+	if len(apGroups) == 0 {
+		return dataset{}, fmt.Errorf("No AP groups configured, use -ap-groups")
+	}
+
+	var (
+		groups   = make(map[int]*dumpFormat)
+		order    []int
+		unmapped int
+	)
+	for _, c := range counts {
+		g, exists := groupForAP(c.Name)
+		if !exists {
+			unmapped++
+			continue
+		}
+
+		d, exists := groups[g.GroupID]
+		if !exists {
+			d = &dumpFormat{GroupID: g.GroupID, GroupName: g.Name, ParentID: g.ParentID}
+			groups[g.GroupID] = d
+			order = append(order, g.GroupID)
+		}
+		if c.invalid != nil {
+			d.invalidate("client_count", c.invalid)
+			continue
+		}
+		d.ClientCount += c.Clients
+		d.AccessPoints = append(d.AccessPoints, c)
+	}
+	if unmapped > 0 {
+		log.Printf("WARNING: %d access points don't belong to any group", unmapped)
+	}
+
+	data := make(dataset, len(order))
+	for i, id := range order {
+		groups[id].annotate(timestamp)
+		data[i] = *groups[id]
+	}
+	return data, nil
+}
+
+// parseCount converts a client count from an export, which may be blank for
+// access points that are down.
+func parseCount(ap, value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to convert client count of %s, %s, to int => {%s}", ap, value, err)
+	}
+	return count, nil
+}
+
+// apCSVDecoder handles CSV exports with a row per access point, as produced by
+// AirWave and Prime reports.
+type apCSVDecoder struct {
+	nameColumn  string
+	countColumn string
+}
+
+// header searches the first rows of the export for the one naming both columns.
+// The reader is left at the first row after the header.
+func (d apCSVDecoder) header(reader *csv.Reader) (name, count int, err error) {
+	for i := 0; i < maxHeaderSearch; i++ {
+		row, err := reader.Read()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		name, count = -1, -1
+		for j, column := range row {
+			switch strings.TrimSpace(column) {
+			case d.nameColumn:
+				name = j
+			case d.countColumn:
+				count = j
+			}
+		}
+		if name >= 0 && count >= 0 {
+			return name, count, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("No header with '%s' and '%s' columns", d.nameColumn, d.countColumn)
+}
+
+// newReader creates a CSV reader that allows a varying number of columns, as
+// title rows are usually shorter than the rows of data.
+func newReader(contents []byte) *csv.Reader {
+	reader := csv.NewReader(bytes.NewReader(contents))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	return reader
+}
+
+// Sniff implements Decoder.
+func (d apCSVDecoder) Sniff(contents []byte) bool {
+	_, _, err := d.header(newReader(contents))
+	return err == nil
+}
+
+// Decode implements Decoder.
+func (d apCSVDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	reader := newReader(contents)
+	name, count, err := d.header(reader)
+	if err != nil {
+		return dataset{}, fmt.Errorf("Failed to read CSV header => %s", err.Error())
+	}
+
+	var counts []apCount
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return dataset{}, fmt.Errorf("Failed to read CSV => %s", err.Error())
+		}
+		// skip totals and other short rows
+		if len(row) <= name || len(row) <= count || strings.TrimSpace(row[name]) == "" {
+			continue
+		}
+
+		ap := strings.TrimSpace(row[name])
+		clients, err := parseCount(ap, row[count])
+		counts = append(counts, apCount{Name: ap, Clients: clients, invalid: err})
+	}
+
+	return aggregateAPs(timestamp, counts)
+}
+
+// airwaveAPList is the `ap_list.xml` export from AirWave.
+type airwaveAPList struct {
+	XMLName xml.Name `xml:"amp_ap_list"`
+	APs     []struct {
+		Name        string `xml:"name"`
+		ClientCount string `xml:"client_count"`
+	} `xml:"ap"`
+}
+
+// airwaveXMLDecoder handles AirWave's `ap_list.xml` export.
+type airwaveXMLDecoder struct{}
+
+// Sniff implements Decoder.
+func (airwaveXMLDecoder) Sniff(contents []byte) bool {
+	return bytes.Contains(contents, []byte("amp_ap_list"))
+}
+
+// Decode implements Decoder.
+func (airwaveXMLDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	var list airwaveAPList
+	if err := xml.Unmarshal(contents, &list); err != nil {
+		return dataset{}, fmt.Errorf("Error parsing AirWave XML => %s", err.Error())
+	}
+
+	counts := make([]apCount, len(list.APs))
+	for i, ap := range list.APs {
+		clients, err := parseCount(ap.Name, ap.ClientCount)
+		counts[i] = apCount{Name: strings.TrimSpace(ap.Name), Clients: clients, invalid: err}
+	}
+	return aggregateAPs(timestamp, counts)
+}
+
+// primeQueryResponse is the AccessPointDetails response from the Prime API.
+type primeQueryResponse struct {
+	XMLName  xml.Name `xml:"queryResponse"`
+	Entities []struct {
+		AP struct {
+			Name        string `xml:"name"`
+			ClientCount string `xml:"clientCount"`
+		} `xml:"accessPointDetailsDTO"`
+	} `xml:"entity"`
+}
+
+// primeXMLDecoder handles the AccessPointDetails export from Prime.
+type primeXMLDecoder struct{}
+
+// Sniff implements Decoder.
+func (primeXMLDecoder) Sniff(contents []byte) bool {
+	return bytes.Contains(contents, []byte("accessPointDetailsDTO"))
+}
+
+// Decode implements Decoder.
+func (primeXMLDecoder) Decode(timestamp time.Time, contents []byte) (dataset, error) {
+	var response primeQueryResponse
+	if err := xml.Unmarshal(contents, &response); err != nil {
+		return dataset{}, fmt.Errorf("Error parsing Prime XML => %s", err.Error())
+	}
+
+	counts := make([]apCount, len(response.Entities))
+	for i, e := range response.Entities {
+		clients, err := parseCount(e.AP.Name, e.AP.ClientCount)
+		counts[i] = apCount{Name: strings.TrimSpace(e.AP.Name), Clients: clients, invalid: err}
+	}
+	return aggregateAPs(timestamp, counts)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+)
+
+var vendorTests = []struct {
+	filename string
+	decoder  string
+}{
+	{"airwave.csv", "airwave-csv"},
+	{"airwave_ap_list.xml", "airwave-xml"},
+	{"prime.csv", "prime-csv"},
+	{"prime_ap_details.xml", "prime-xml"},
+}
+
+// expectedAPCounts are the group totals for every sample export.
+var expectedAPCounts = map[int]int{
+	130: 262,
+	131: 174,
+	152: 24,
+}
+
+// TestVendorExports decodes each bundled sample export and checks the group totals.
+func TestVendorExports(t *testing.T) {
+	if err := loadAPGroups("test_data/vendor/ap_groups.json"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { apGroups = nil }()
+
+	for _, tt := range vendorTests {
+		contents, err := ioutil.ReadFile(path.Join("test_data/vendor", tt.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name, decoder, err := decoderFor(tt.filename, contents)
+		if err != nil || name != tt.decoder {
+			t.Errorf("Expected the %s decoder for %s, found %s => %v", tt.decoder, tt.filename, name, err)
+			continue
+		}
+
+		data, err := decoder.Decode(time.Time{}, contents)
+		if err != nil {
+			t.Errorf("Failed to decode %s => %s", tt.filename, err)
+			continue
+		}
+		if len(data) != len(expectedAPCounts) {
+			t.Errorf("Expected %d groups from %s, found %d", len(expectedAPCounts), tt.filename, len(data))
+		}
+		for _, d := range data {
+			if expected := expectedAPCounts[d.GroupID]; d.ClientCount != expected {
+				t.Errorf("Expected %d clients for group %d from %s, found %d",
+					expected, d.GroupID, tt.filename, d.ClientCount)
+			}
+			if d.GroupID == 130 && len(d.AccessPoints) != 2 {
+				t.Errorf("Access points not kept from %s, found %#v", tt.filename, d.AccessPoints)
+			}
+			if d.GroupID == 130 && (d.ParentName != "Butler" || d.Floor.Int64 != 2) {
+				t.Errorf("Group not annotated from %s, found %#v", tt.filename, d)
+			}
+		}
+	}
+}
+
+// TestVendorWithoutGroups checks that exports are rejected without a mapping.
+func TestVendorWithoutGroups(t *testing.T) {
+	if _, err := aggregateAPs(time.Time{}, []apCount{{Name: "BUT-2-AP01", Clients: 5}}); err == nil {
+		t.Error("Expected error without AP groups")
+	}
+}
+
+// TestVendorInvalidCount checks that an access point's unreadable count invalidates
+// only its group, which is then handled by the policy.
+func TestVendorInvalidCount(t *testing.T) {
+	if err := loadAPGroups("test_data/vendor/ap_groups.json"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { apGroups = nil }()
+
+	for _, tt := range vendorTests {
+		contents, err := ioutil.ReadFile(path.Join("test_data/vendor", tt.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = bytes.Replace(contents, []byte("122"), []byte("n/a"), 1)
+
+		_, decoder, err := decoderFor(tt.filename, contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := decoder.Decode(time.Time{}, contents)
+		if err != nil {
+			t.Errorf("Expected %s to decode with an invalid count => %s", tt.filename, err)
+			continue
+		}
+
+		kept, rejects, err := data.validate(invalidSkip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rejects) != 1 || rejects[0].GroupID.Int64 != 130 || rejects[0].Field != "client_count" {
+			t.Errorf("Expected the count of group 130 from %s to be invalid, found %v", tt.filename, rejects)
+		}
+		if len(kept) != len(expectedAPCounts)-1 {
+			t.Errorf("Expected the other groups from %s to be kept, found %d", tt.filename, len(kept))
+		}
+
+		if kept, _, _ = data.validate(invalidNull); len(kept) != len(expectedAPCounts) {
+			t.Errorf("Expected every group from %s to be kept under the null policy, found %d", tt.filename, len(kept))
+		}
+		for _, d := range kept {
+			if d.GroupID == 130 && (!d.NullCount || d.AccessPoints != nil) {
+				t.Errorf("Expected a null count for group 130 from %s, found %#v", tt.filename, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+)
+
+func init() {
+	commands["formats"] = command{
+		description: "list the versions of the dump format along with their JSON Schemas",
+		run:         formatsCommand,
+	}
+}
+
+// versionKey is the optional top level key of a CUIT dump declaring its format
+// version. Streamed dumps need it before any of the groups.
+const versionKey = "format_version"
+
+// formatVersion is a version of the shape of the records in a dump.
+// Fields are the keys parsed into a dumpFormat, anything else is kept in its
+// Extras. StringNumbers is whether numbers may be string-encoded.
+// Schema is the JSON Schema for the version, published in `schemas`.
+type formatVersion struct {
+	Version       int
+	Description   string
+	Fields        map[string]bool
+	StringNumbers bool
+	Schema        string
+}
+
+// baseFields are the keys of every version.
+var baseFields = []string{"group_id", "name", "parent_id", "client_count"}
+
+// formatVersions lists every version in order, the last is the current version.
+var formatVersions = []formatVersion{
+	{
+		Version:     1,
+		Description: "name, parent_id & client_count, numbers encoded as numbers",
+		Fields:      fieldSet(baseFields),
+		Schema:      "schemas/cuit-v1.json",
+	},
+	{
+		Version:       2,
+		Description:   "numbers may be string-encoded",
+		Fields:        fieldSet(baseFields),
+		StringNumbers: true,
+		Schema:        "schemas/cuit-v2.json",
+	},
+	{
+		Version:       3,
+		Description:   "adds the optional access_points, bands, ssids & authentication",
+		Fields:        fieldSet(append([]string{"access_points", "bands", "ssids", "authentication"}, baseFields...)),
+		StringNumbers: true,
+		Schema:        "schemas/cuit-v3.json",
+	},
+}
+
+// currentVersion is the latest format version, used when a dump doesn't declare one.
+var currentVersion = formatVersions[len(formatVersions)-1]
+
+// fieldSet makes a set of the field names.
+func fieldSet(fields []string) map[string]bool {
+	set := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		set[f] = true
+	}
+	return set
+}
+
+// lookupVersion finds a format version by number.
+func lookupVersion(version int) (formatVersion, error) {
+	for _, v := range formatVersions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return formatVersion{}, fmt.Errorf("Unknown format version, %d", version)
+}
+
+// parseVersion reads the value of a dump's `versionKey`, which may be a number or a
+// string-encoded number.
+func parseVersion(value interface{}) (formatVersion, error) {
+	version, err := intField(map[string]interface{}{versionKey: value}, versionKey)
+	if err != nil {
+		return formatVersion{}, err
+	}
+	return lookupVersion(version)
+}
+
+// fingerprint finds the earliest version a record's fields fit. A record carrying
+// its own string-encoded 'group_id' needs a version allowing string numbers too.
+func fingerprint(raw map[string]interface{}, stringNumbers bool) int {
+	if _, isString := raw["group_id"].(string); isString {
+		stringNumbers = true
+	}
+	for _, v := range formatVersions {
+		if stringNumbers && !v.StringNumbers {
+			continue
+		}
+		fits := true
+		for key := range raw {
+			if currentVersion.Fields[key] && !v.Fields[key] {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return v.Version
+		}
+	}
+	return currentVersion.Version
+}
+
+// formatVersion is the version of the dump, the latest version of any of its
+// records. Zero if the format isn't versioned.
+func (data dataset) formatVersion() int {
+	var version int
+	for _, d := range data {
+		if d.Version > version {
+			version = d.Version
+		}
+	}
+	return version
+}
+
+// formatsCommand prints every format version, its description and its schema.
+func formatsCommand(args []string) error {
+	newFlagSet("formats").Parse(args)
+
+	for _, v := range formatVersions {
+		fmt.Printf("%d\t%s\t%s\n", v.Version, v.Schema, v.Description)
+	}
+	return nil
+}
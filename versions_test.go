@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var versionTests = []struct {
+	record  string
+	version int
+}{
+	{`{"name": "Lerner 3", "client_count": 70, "parent_id": 84}`, 1},
+	{`{"name": "Lerner 3", "client_count": "70", "parent_id": 84}`, 2},
+	{`{"name": "Lerner 3", "client_count": 70, "parent_id": 84, "bands": {"5ghz": 70}}`, 3},
+	{`{"name": "Lerner 3", "client_count": 70, "parent_id": 84, "firmware": "8.2"}`, 1},
+	{`{"group_id": "152", "name": "Lerner 3", "client_count": 70, "parent_id": 84}`, 2},
+}
+
+// TestFingerprint checks that undeclared records are given the earliest version they fit.
+func TestFingerprint(t *testing.T) {
+	for _, tt := range versionTests {
+		var d dumpFormat
+		if err := json.Unmarshal([]byte(tt.record), &d); err != nil {
+			t.Fatalf("Failed to unmarshal %s => %s", tt.record, err)
+		}
+		if d.Version != tt.version {
+			t.Errorf("Expected version %d for %s, found %d", tt.version, tt.record, d.Version)
+		}
+	}
+}
+
+// TestDeclaredVersion checks that a declared version is recorded and held to.
+func TestDeclaredVersion(t *testing.T) {
+	dump := `{
+  "format_version": 1,
+  "152": {"name": "Lerner 3", "client_count": "70", "parent_id": 84},
+  "131": {"group_id": "131", "name": "Butler Library 3", "client_count": 328, "parent_id": 103, "bands": {"5ghz": 328}}
+}`
+	for _, decode := range []func() (dataset, error){
+		func() (dataset, error) { return parseData(time.Time{}, []byte(dump)) },
+		func() (dataset, error) { return (objectDecoder{}).Decode(time.Time{}, []byte(dump)) },
+		func() (dataset, error) { return collect(objectDecoder{}, time.Time{}, []byte(dump)) },
+	} {
+		data, err := decode()
+		if err != nil {
+			t.Fatalf("Failed to parse declared dump => %s", err)
+		}
+		if len(data) != 2 || data.formatVersion() != 1 {
+			t.Fatalf("Expected 2 groups in version 1, found %#v", data)
+		}
+		for _, d := range data {
+			switch d.GroupID {
+			case 152:
+				if len(d.Invalid) != 1 || d.Invalid[0].Field != "client_count" {
+					t.Errorf("Expected string-encoded count to be invalid in version 1, found %#v", d.Invalid)
+				}
+			case 131:
+				if d.Breakdown != nil || d.Extras["bands"] == nil {
+					t.Errorf("Expected bands to be kept as an extra in version 1, found %#v", d)
+				}
+			}
+		}
+	}
+
+	if _, err := parseData(time.Time{}, []byte(`{"format_version": 99}`)); err == nil {
+		t.Error("Expected error for unknown format version")
+	}
+}
+
+// TestSchemas checks that every version's schema is published and describes its fields.
+func TestSchemas(t *testing.T) {
+	for _, v := range formatVersions {
+		contents, err := ioutil.ReadFile(v.Schema)
+		if err != nil {
+			t.Fatalf("Failed to read schema for version %d => %s", v.Version, err)
+		}
+
+		var schema struct {
+			Properties struct {
+				FormatVersion struct {
+					Const int `json:"const"`
+				} `json:"format_version"`
+			} `json:"properties"`
+			Definitions struct {
+				Group struct {
+					Properties map[string]interface{} `json:"properties"`
+				} `json:"group"`
+			} `json:"definitions"`
+		}
+		if err = json.Unmarshal(contents, &schema); err != nil {
+			t.Fatalf("Failed to parse schema for version %d => %s", v.Version, err)
+		}
+
+		if schema.Properties.FormatVersion.Const != v.Version {
+			t.Errorf("Expected schema %s to declare version %d", v.Schema, v.Version)
+		}
+		for field := range v.Fields {
+			// the group ID is the key of each group
+			if _, exists := schema.Definitions.Group.Properties[field]; !exists && field != "group_id" {
+				t.Errorf("Expected schema %s to describe '%s'", v.Schema, field)
+			}
+		}
+	}
+}